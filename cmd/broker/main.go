@@ -0,0 +1,49 @@
+// Command broker runs an in-memory AMQP broker, so a game can be played without RabbitMQ. Build it
+// with `go build -o peril-broker ./cmd/broker`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/broker"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func main() {
+	addr := flag.String("addr", ":5672", "address to listen on")
+	flag.Parse()
+
+	b := broker.New()
+	// RabbitMQ needs these set up by hand before the first game; the embedded broker declares them itself.
+	exchanges := []struct{ name, kind string }{
+		{routing.ExchangePerilDirect, broker.ExchangeDirect},
+		{routing.ExchangePerilTopic, broker.ExchangeTopic},
+		{"peril_dlx", broker.ExchangeFanout},
+	}
+	for _, ex := range exchanges {
+		if err := b.DeclareExchange(ex.name, ex.kind); err != nil {
+			fmt.Println("Failed to declare exchange:", err)
+			return
+		}
+	}
+	if err := b.DeclareQueue("peril_dlq", "peril_dlx", ""); err != nil {
+		fmt.Println("Failed to declare dead letter queue:", err)
+		return
+	}
+
+	go func() {
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt)
+		<-signalChan
+		fmt.Println("Shutting down Peril broker...")
+		b.Close()
+	}()
+
+	fmt.Printf("Peril broker listening on %s...\n", *addr)
+	if err := b.ListenAndServe(*addr); err != nil {
+		fmt.Println("Broker stopped:", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/session"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -24,38 +25,72 @@ func main() {
 	defer conn.Close()
 	fmt.Println("Peril game client connected to RabbitMQ!")
 
-	publishCh, err := conn.Channel()
+	gameState, err := gamelogic.ClientWelcome()
 	if err != nil {
-		log.Fatalf("could not create channel: %v", err)
+		fmt.Println("Failed to get username:", err)
+		return
+	}
+	username := gameState.GetUsername()
+	// A resumed session goes back to its game once the lobby lets the player in again.
+	resumeGameID := gameState.GetGameID()
+	gameState.SetGameID("")
+	savePath := gamelogic.SavePath(username)
+	if err := routing.ValidUsername(username); err != nil {
+		fmt.Println("Invalid username:", err)
+		return
 	}
 
-	username, err := gamelogic.ClientWelcome()
+	identityDir, err := pubsub.DefaultIdentityDir()
 	if err != nil {
-		fmt.Println("Failed to get username:", err)
+		fmt.Println("Failed to find your signing key:", err)
 		return
 	}
-
-	gameState := gamelogic.NewGameState(username)
-	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, routing.PauseKey+"."+username, routing.PauseKey, pubsub.SimpleQueueTypeTransient, handlerPause(gameState))
+	identity, err := pubsub.LoadIdentity(identityDir, username)
 	if err != nil {
-		fmt.Println("Failed to subscribe to pause queue:", err)
+		fmt.Println("Failed to load your signing key:", err)
+		return
+	}
+	// Only the server's messages are verified, and its key is the only one trusted.
+	keyStore := pubsub.NewKeyStore()
+	if err := keyStore.TrustFile(pubsub.ServerKeyPathFromEnv()); err != nil {
+		fmt.Println("Failed to load the server's key, is the server running?", err)
 		return
 	}
-	fmt.Println("Successfully subscribed to pause queue...")
 
-	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, routing.ArmyMovesPrefix+"."+username, routing.ArmyMovesPrefix+".*", pubsub.SimpleQueueTypeTransient, handlerMove(gameState, publishCh))
+	player, err := session.New(conn, gameState, identity, keyStore, session.Handlers{
+		Lobby: func(gamelogic.LobbyReply) { fmt.Print("> ") },
+		Pause: func(routing.PlayingState) { fmt.Print("> ") },
+		Delta: func(delta gamelogic.StateDelta) {
+			// The server's snapshot replaces the save on resume, so the save only needs to get the
+			// player back into their game. It is written when the game is entered, reset or over,
+			// and on quit, rather than on every delta.
+			switch delta.Kind {
+			case gamelogic.DeltaKindSnapshot, gamelogic.DeltaKindRestored, gamelogic.DeltaKindGameOver:
+				if err := gameState.Save(savePath); err != nil {
+					fmt.Println("Failed to save your game:", err)
+				}
+			}
+			fmt.Print("> ")
+		},
+		Error: func(err error) {
+			fmt.Println("Error:", err)
+			fmt.Print("> ")
+		},
+	})
 	if err != nil {
-		fmt.Println("Failed to subscribe to army moves queue:", err)
+		fmt.Println("Failed to start the session:", err)
 		return
 	}
-	fmt.Println("Successfully subscribed to army moves queue...")
+	fmt.Println("Successfully subscribed to lobby replies queue...")
 
-	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix, routing.WarRecognitionsPrefix+".*", pubsub.SimpleQueueTypeDurable, handlerWar(gameState, publishCh))
-	if err != nil {
-		fmt.Println("Failed to subscribe to war queue:", err)
+	lobbyRequest := gamelogic.LobbyRequest{Username: username, Command: gamelogic.LobbyCommandList}
+	if resumeGameID != "" {
+		lobbyRequest = gamelogic.LobbyRequest{Username: username, Command: gamelogic.LobbyCommandJoin, GameID: resumeGameID}
+	}
+	if err := player.Lobby(lobbyRequest); err != nil {
+		fmt.Println("Failed to reach the lobby:", err)
 		return
 	}
-	fmt.Println("Successfully subscribed to war queue...")
 
 	for {
 		input := gamelogic.GetInput()
@@ -66,17 +101,28 @@ func main() {
 
 		cmd := strings.ToLower(input[0])
 		switch cmd {
-		case "spawn":
-			err = gameState.CommandSpawn(input)
-		case "move":
-			armyMove, err := gameState.CommandMove(input)
+		case "spawn", "move":
+			var req gamelogic.CommandRequest
+			if cmd == "spawn" {
+				req, err = gameState.CommandSpawn(input)
+			} else {
+				req, err = gameState.CommandMove(input)
+			}
+			if err == nil {
+				err = player.Command(req)
+			}
+		case "games", "create", "join":
+			var req gamelogic.LobbyRequest
+			req, err = gameState.CommandLobby(input)
 			if err == nil {
-				fmt.Println("Move successful!")
-				pubsub.PublishJSON(publishCh, routing.ExchangePerilTopic, routing.ArmyMovesPrefix+"."+username, armyMove)
-				fmt.Println("Move successfully published!")
+				err = player.Lobby(req)
 			}
 		case "status":
 			gameState.CommandStatus()
+		case "map":
+			gameState.CommandMap()
+		case "ranks":
+			gameState.CommandRanks()
 		case "help":
 			gamelogic.PrintClientHelp()
 		case "spam":
@@ -97,7 +143,7 @@ func main() {
 					Message:     mesage,
 					Username:    username,
 				}
-				err = pubsub.PublishGOB(publishCh, routing.ExchangePerilTopic, routing.GameLogSlug+"."+username, gameLog)
+				err = player.Log(gameLog)
 				if err != nil {
 					fmt.Println("Failed to publish game log:", err)
 					continue
@@ -105,6 +151,12 @@ func main() {
 				fmt.Println("Spam message successfully published!")
 			}
 		case "quit":
+			if err := player.Leave(); err != nil {
+				fmt.Println("Failed to say goodbye:", err)
+			}
+			if err := gameState.Save(savePath); err != nil {
+				fmt.Println("Failed to save your game:", err)
+			}
 			gamelogic.PrintQuit()
 			return
 		default:
@@ -121,78 +173,3 @@ func main() {
 	// <-signalChan
 	// fmt.Println("Shutting down...")
 }
-
-func handlerPause(gs *gamelogic.GameState) func(routing.PlayingState) pubsub.AckType {
-	return func(state routing.PlayingState) pubsub.AckType {
-		defer fmt.Print("> ")
-		gs.HandlePause(state)
-		return pubsub.Ack
-	}
-}
-
-func handlerMove(gs *gamelogic.GameState, publishCh *amqp.Channel) func(gamelogic.ArmyMove) pubsub.AckType {
-	return func(move gamelogic.ArmyMove) pubsub.AckType {
-		defer fmt.Print("> ")
-		moveOutcome := gs.HandleMove(move)
-		switch moveOutcome {
-		case gamelogic.MoveOutComeSafe:
-			return pubsub.Ack
-		case gamelogic.MoveOutcomeMakeWar:
-			routingKey := fmt.Sprintf("%s.%s", routing.WarRecognitionsPrefix, move.Player.Username)
-			war := gamelogic.RecognitionOfWar{
-				Attacker: move.Player,
-				Defender: gs.Player,
-			}
-			err := pubsub.PublishJSON(publishCh, routing.ExchangePerilTopic, routingKey, war)
-			if err != nil {
-				return pubsub.NackRequeue
-			}
-			return pubsub.Ack
-		default:
-			return pubsub.NackDiscard
-		}
-	}
-}
-
-func handlerWar(gs *gamelogic.GameState, publishCh *amqp.Channel) func(gamelogic.RecognitionOfWar) pubsub.AckType {
-	return func(war gamelogic.RecognitionOfWar) pubsub.AckType {
-		defer fmt.Print("> ")
-		warOutcome, winner, loser := gs.HandleWar(war)
-		logMessage := ""
-		var ackResult pubsub.AckType
-
-		switch warOutcome {
-		case gamelogic.WarOutcomeNotInvolved:
-			ackResult = pubsub.NackRequeue
-		case gamelogic.WarOutcomeNoUnits:
-			ackResult = pubsub.NackDiscard
-		case gamelogic.WarOutcomeOpponentWon:
-			fallthrough
-		case gamelogic.WarOutcomeYouWon:
-			logMessage = fmt.Sprintf("%s won a war against %s", winner, loser)
-			ackResult = pubsub.Ack
-		case gamelogic.WarOutcomeDraw:
-			logMessage = fmt.Sprintf("A war between %s and %s resulted in a draw", winner, loser)
-			ackResult = pubsub.Ack
-		default:
-			fmt.Println("Unknown war outcome")
-			ackResult = pubsub.NackDiscard
-		}
-
-		if logMessage != "" {
-			gameLog := routing.GameLog{
-				CurrentTime: time.Now(),
-				Message:     logMessage,
-				Username:    war.Attacker.Username,
-			}
-
-			err := pubsub.PublishGOB(publishCh, routing.ExchangePerilTopic, routing.GameLogSlug+"."+war.Attacker.Username, gameLog)
-			if err != nil {
-				fmt.Println("Failed to publish game log:", err)
-				ackResult = pubsub.NackRequeue
-			}
-		}
-
-		return ackResult
-	}
-}
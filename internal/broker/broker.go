@@ -0,0 +1,457 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exchange types supported by the broker.
+const (
+	ExchangeDirect = "direct"
+	ExchangeFanout = "fanout"
+	ExchangeTopic  = "topic"
+)
+
+// How often expired messages are looked for at the head of every queue.
+const expirySweepInterval = 100 * time.Millisecond
+
+// Broker is an in-memory AMQP 0-9-1 broker implementing the parts of the protocol the Peril client
+// and server use: exchanges, queues and bindings, publishing with confirms, consuming with QoS,
+// acks, nacks and rejects, dead-lettering, TTLs, length limits, priorities and streams. Nothing is
+// persisted, so durable queues only last as long as the broker. Any username and password is
+// accepted.
+type Broker struct {
+	mu        sync.Mutex
+	exchanges map[string]*exchange
+	queues    map[string]*queue
+	nextID    uint64
+
+	listener net.Listener
+	conns    map[*connection]struct{}
+	done     chan struct{}
+}
+
+type exchange struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	bindings   []binding
+}
+
+type binding struct {
+	queue *queue
+	key   string
+}
+
+// message is a published message. It is shared by every queue it is routed to and never changed.
+type message struct {
+	exchange   string
+	routingKey string
+	properties []byte
+	body       []byte
+	props      properties
+}
+
+func New() *Broker {
+	b := &Broker{
+		exchanges: map[string]*exchange{},
+		queues:    map[string]*queue{},
+		conns:     map[*connection]struct{}{},
+		done:      make(chan struct{}),
+	}
+	for name, kind := range map[string]string{
+		"":           ExchangeDirect,
+		"amq.direct": ExchangeDirect,
+		"amq.fanout": ExchangeFanout,
+		"amq.topic":  ExchangeTopic,
+	} {
+		b.exchanges[name] = &exchange{name: name, kind: kind, durable: true}
+	}
+	go b.sweepExpired()
+	return b
+}
+
+// DeclareExchange creates an exchange up front, the way a broker definitions file would.
+func (b *Broker) DeclareExchange(name, kind string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.declareExchange(name, kind, true, false, false, false)
+	if err != nil {
+		return errors.New(err.text)
+	}
+	return nil
+}
+
+// DeclareQueue creates a durable queue bound to exchange with key, such as a dead letter queue.
+func (b *Broker) DeclareQueue(name, exchange, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, err := b.declareQueue(nil, name, false, true, false, false, Table{})
+	if err == nil {
+		err = b.bind(nil, q.name, exchange, key)
+	}
+	if err != nil {
+		return errors.New(err.text)
+	}
+	return nil
+}
+
+// Serve accepts AMQP connections on l until Close is called.
+func (b *Broker) Serve(l net.Listener) error {
+	b.mu.Lock()
+	b.listener = l
+	b.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-b.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go b.serveConn(conn)
+	}
+}
+
+func (b *Broker) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return b.Serve(l)
+}
+
+// Close stops accepting connections and closes the open ones.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	select {
+	case <-b.done:
+		b.mu.Unlock()
+		return nil
+	default:
+	}
+	close(b.done)
+	l := b.listener
+	conns := make([]*connection, 0, len(b.conns))
+	for c := range b.conns {
+		conns = append(conns, c)
+	}
+	b.mu.Unlock()
+
+	for _, c := range conns {
+		c.conn.Close()
+	}
+	if l != nil {
+		return l.Close()
+	}
+	return nil
+}
+
+func (b *Broker) serveConn(conn net.Conn) {
+	c := newConnection(b, conn)
+	b.mu.Lock()
+	b.conns[c] = struct{}{}
+	b.mu.Unlock()
+
+	err := c.serve()
+	if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) && !errors.Is(err, errConnectionClosed) {
+		fmt.Printf("connection from %s closed: %v\n", conn.RemoteAddr(), err)
+	}
+
+	b.mu.Lock()
+	delete(b.conns, c)
+	c.cleanup()
+	b.mu.Unlock()
+}
+
+func (b *Broker) sweepExpired() {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			now := time.Now()
+			for _, q := range b.queues {
+				q.expire(now)
+			}
+			b.mu.Unlock()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *Broker) generateName(prefix string) string {
+	b.nextID++
+	return fmt.Sprintf("%s%d", prefix, b.nextID)
+}
+
+func (b *Broker) declareExchange(name, kind string, durable, autoDelete, internal, passive bool) (*exchange, *amqpError) {
+	ex, ok := b.exchanges[name]
+	if passive {
+		if !ok {
+			return nil, notFound("no exchange '%s'", name)
+		}
+		return ex, nil
+	}
+	if ok {
+		if ex.kind != kind {
+			return nil, preconditionFailed("exchange '%s' already exists with type %s", name, ex.kind)
+		}
+		return ex, nil
+	}
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return nil, accessRefused("exchange name '%s' is reserved", name)
+	}
+	switch kind {
+	case ExchangeDirect, ExchangeFanout, ExchangeTopic:
+	default:
+		return nil, commandInvalid("exchange type %s is not supported", kind)
+	}
+	ex = &exchange{name: name, kind: kind, durable: durable, autoDelete: autoDelete, internal: internal}
+	b.exchanges[name] = ex
+	return ex, nil
+}
+
+func (b *Broker) deleteExchange(name string, ifUnused bool) *amqpError {
+	ex, ok := b.exchanges[name]
+	if !ok {
+		return nil
+	}
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return accessRefused("exchange '%s' can not be deleted", name)
+	}
+	if ifUnused && len(ex.bindings) > 0 {
+		return preconditionFailed("exchange '%s' is in use", name)
+	}
+	delete(b.exchanges, name)
+	return nil
+}
+
+func (b *Broker) declareQueue(owner *connection, name string, passive, durable, exclusive, autoDelete bool, args Table) (*queue, *amqpError) {
+	if name != "" {
+		if q, ok := b.queues[name]; ok {
+			if err := q.checkAccess(owner); err != nil {
+				return nil, err
+			}
+			if !passive && (q.durable != durable || q.exclusive != exclusive || q.autoDelete != autoDelete) {
+				return nil, preconditionFailed("queue '%s' already exists with different properties", name)
+			}
+			return q, nil
+		}
+	}
+	if passive {
+		return nil, notFound("no queue '%s'", name)
+	}
+	if name == "" {
+		name = b.generateName("amq.gen-")
+	} else if strings.HasPrefix(name, "amq.") {
+		return nil, accessRefused("queue name '%s' is reserved", name)
+	}
+
+	q, err := newQueue(b, name, durable, exclusive, autoDelete, args)
+	if err != nil {
+		return nil, err
+	}
+	if exclusive {
+		q.owner = owner
+	}
+	b.queues[name] = q
+	if ex, ok := b.exchanges[""]; ok {
+		ex.bindings = append(ex.bindings, binding{queue: q, key: name})
+	}
+	return q, nil
+}
+
+func (b *Broker) lookupQueue(owner *connection, name string) (*queue, *amqpError) {
+	q, ok := b.queues[name]
+	if !ok {
+		return nil, notFound("no queue '%s'", name)
+	}
+	if err := q.checkAccess(owner); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (b *Broker) bind(owner *connection, queueName, exchangeName, key string) *amqpError {
+	q, err := b.lookupQueue(owner, queueName)
+	if err != nil {
+		return err
+	}
+	ex, ok := b.exchanges[exchangeName]
+	if !ok {
+		return notFound("no exchange '%s'", exchangeName)
+	}
+	if exchangeName == "" {
+		return accessRefused("queues can not be bound to the default exchange")
+	}
+	for _, bd := range ex.bindings {
+		if bd.queue == q && bd.key == key {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, binding{queue: q, key: key})
+	return nil
+}
+
+func (b *Broker) unbind(owner *connection, queueName, exchangeName, key string) *amqpError {
+	q, err := b.lookupQueue(owner, queueName)
+	if err != nil {
+		return err
+	}
+	ex, ok := b.exchanges[exchangeName]
+	if !ok {
+		return notFound("no exchange '%s'", exchangeName)
+	}
+	for i, bd := range ex.bindings {
+		if bd.queue == q && bd.key == key {
+			ex.bindings = append(ex.bindings[:i], ex.bindings[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (b *Broker) deleteQueue(q *queue) int {
+	delete(b.queues, q.name)
+	for _, ex := range b.exchanges {
+		kept := ex.bindings[:0]
+		for _, bd := range ex.bindings {
+			if bd.queue != q {
+				kept = append(kept, bd)
+			}
+		}
+		ex.bindings = kept
+		if ex.autoDelete && len(ex.bindings) == 0 && ex.name != "" {
+			delete(b.exchanges, ex.name)
+		}
+	}
+	for _, cons := range q.consumers {
+		cons.ch.dropConsumer(cons, true)
+	}
+	q.consumers = nil
+	q.deleted = true
+	count := len(q.messages)
+	q.messages = nil
+	q.log = nil
+	return count
+}
+
+// route returns the queues a message published to exchangeName with key should go to.
+func (b *Broker) route(exchangeName, key string) ([]*queue, *amqpError) {
+	ex, ok := b.exchanges[exchangeName]
+	if !ok {
+		return nil, notFound("no exchange '%s'", exchangeName)
+	}
+	seen := map[*queue]bool{}
+	var queues []*queue
+	for _, bd := range ex.bindings {
+		if seen[bd.queue] {
+			continue
+		}
+		var match bool
+		switch ex.kind {
+		case ExchangeDirect:
+			match = bd.key == key
+		case ExchangeFanout:
+			match = true
+		case ExchangeTopic:
+			match = matchTopic(bd.key, key)
+		}
+		if match {
+			seen[bd.queue] = true
+			queues = append(queues, bd.queue)
+		}
+	}
+	return queues, nil
+}
+
+// publish routes msg and enqueues it. It returns false if a queue refused the message because of
+// its overflow policy, for publisher confirms.
+func (b *Broker) publish(msg *message) (bool, *amqpError) {
+	queues, err := b.route(msg.exchange, msg.routingKey)
+	if err != nil {
+		return false, err
+	}
+	accepted := true
+	for _, q := range queues {
+		if !q.enqueue(msg) {
+			accepted = false
+		}
+	}
+	return accepted, nil
+}
+
+// deadLetter republishes msg to the queue's dead letter exchange, if it has one.
+func (b *Broker) deadLetter(q *queue, msg *message) {
+	if q.deadLetterExchange == nil {
+		return
+	}
+	key := msg.routingKey
+	if q.deadLetterRoutingKey != "" {
+		key = q.deadLetterRoutingKey
+	}
+	dead := &message{
+		exchange:   *q.deadLetterExchange,
+		routingKey: key,
+		properties: msg.properties,
+		body:       msg.body,
+		// Dead-lettered messages do not expire again.
+		props: properties{priority: msg.props.priority},
+	}
+	queues, err := b.route(dead.exchange, dead.routingKey)
+	if err != nil {
+		return
+	}
+	for _, target := range queues {
+		// Never dead-letter back into the queue the message came from.
+		if target != q {
+			target.enqueue(dead)
+		}
+	}
+}
+
+// matchTopic matches a routing key against a topic binding key, where * matches one word and #
+// matches zero or more words.
+func matchTopic(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(words); i++ {
+				if matchWords(pattern[1:], words[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(words) == 0 {
+				return false
+			}
+		default:
+			if len(words) == 0 || words[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		words = words[1:]
+	}
+	return len(words) == 0
+}
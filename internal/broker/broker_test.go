@@ -0,0 +1,331 @@
+package broker
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// dial starts a broker on a local port and connects to it with the AMQP client the game uses.
+func dial(t *testing.T) *amqp.Connection {
+	t.Helper()
+	b := New()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve(l)
+	t.Cleanup(func() { b.Close() })
+	conn, err := amqp.Dial("amqp://guest:guest@" + l.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func openChannel(t *testing.T, conn *amqp.Connection) *amqp.Channel {
+	t.Helper()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	return ch
+}
+
+func declare(t *testing.T, ch *amqp.Channel, name string, args amqp.Table) {
+	t.Helper()
+	if _, err := ch.QueueDeclare(name, true, false, false, false, args); err != nil {
+		t.Fatalf("could not declare %s: %v", name, err)
+	}
+}
+
+func publish(t *testing.T, ch *amqp.Channel, key string, msg amqp.Publishing) {
+	t.Helper()
+	if err := ch.Publish("", key, false, false, msg); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+}
+
+func consume(t *testing.T, ch *amqp.Channel, queue string, args amqp.Table) <-chan amqp.Delivery {
+	t.Helper()
+	deliveries, err := ch.Consume(queue, "", false, false, false, false, args)
+	if err != nil {
+		t.Fatalf("could not consume from %s: %v", queue, err)
+	}
+	return deliveries
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no message was delivered")
+		return amqp.Delivery{}
+	}
+}
+
+func expectNone(t *testing.T, deliveries <-chan amqp.Delivery) {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected message %q", d.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// receiveBodies acks and returns the bodies of the next n messages.
+func receiveBodies(t *testing.T, deliveries <-chan amqp.Delivery, n int) []string {
+	t.Helper()
+	var bodies []string
+	for i := 0; i < n; i++ {
+		d := receive(t, deliveries)
+		bodies = append(bodies, string(d.Body))
+		if err := d.Ack(false); err != nil {
+			t.Fatalf("could not ack: %v", err)
+		}
+	}
+	return bodies
+}
+
+func expectBodies(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, expected %q", got, want)
+		}
+	}
+}
+
+func expectPrecondition(t *testing.T, err error) {
+	t.Helper()
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.PreconditionFailed {
+		t.Fatalf("got %v, expected a precondition failure", err)
+	}
+}
+
+func TestTopicRoutingAndAcks(t *testing.T) {
+	conn := dial(t)
+	ch := openChannel(t, conn)
+	if err := ch.ExchangeDeclare("peril_topic", "topic", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	declare(t, ch, "moves", nil)
+	if err := ch.QueueBind("moves", "army_moves.*", "peril_topic", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 2))
+	for _, key := range []string{"army_moves.alice", "war.alice"} {
+		if err := ch.Publish("peril_topic", key, false, false, amqp.Publishing{Body: []byte(key)}); err != nil {
+			t.Fatal(err)
+		}
+		if c := <-confirms; !c.Ack {
+			t.Fatalf("publish to %s was not confirmed", key)
+		}
+	}
+
+	deliveries := consume(t, ch, "moves", nil)
+	d := receive(t, deliveries)
+	if string(d.Body) != "army_moves.alice" || d.Redelivered {
+		t.Fatalf("got %q, redelivered %v", d.Body, d.Redelivered)
+	}
+	if err := d.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+	d = receive(t, deliveries)
+	if string(d.Body) != "army_moves.alice" || !d.Redelivered {
+		t.Fatalf("got %q, redelivered %v, expected the requeued message", d.Body, d.Redelivered)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, deliveries)
+}
+
+func TestDeadLettering(t *testing.T) {
+	conn := dial(t)
+	ch := openChannel(t, conn)
+	if err := ch.ExchangeDeclare("peril_dlx", "fanout", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	declare(t, ch, "peril_dlq", nil)
+	if err := ch.QueueBind("peril_dlq", "", "peril_dlx", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	declare(t, ch, "rejects", amqp.Table{"x-dead-letter-exchange": "peril_dlx"})
+	declare(t, ch, "expires", amqp.Table{"x-dead-letter-exchange": "peril_dlx", "x-message-ttl": int32(20)})
+
+	publish(t, ch, "rejects", amqp.Publishing{Body: []byte("rejected")})
+	d := receive(t, consume(t, ch, "rejects", nil))
+	if err := d.Nack(false, false); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, ch, "expires", amqp.Publishing{Body: []byte("expired")})
+	publish(t, ch, "expires", amqp.Publishing{Body: []byte("expired by the publisher"), Expiration: "10"})
+
+	dead := consume(t, ch, "peril_dlq", nil)
+	expectBodies(t, receiveBodies(t, dead, 3), "rejected", "expired", "expired by the publisher")
+}
+
+func TestMaxLength(t *testing.T) {
+	conn := dial(t)
+	ch := openChannel(t, conn)
+	declare(t, ch, "drop-head", amqp.Table{"x-max-length": int32(2)})
+	declare(t, ch, "reject", amqp.Table{"x-max-length": int32(1), "x-overflow": "reject-publish"})
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 5))
+
+	for _, body := range []string{"1", "2", "3"} {
+		publish(t, ch, "drop-head", amqp.Publishing{Body: []byte(body)})
+		<-confirms
+	}
+	expectBodies(t, receiveBodies(t, consume(t, ch, "drop-head", nil), 2), "2", "3")
+
+	for _, want := range []bool{true, false} {
+		publish(t, ch, "reject", amqp.Publishing{Body: []byte("move")})
+		if c := <-confirms; c.Ack != want {
+			t.Errorf("publish confirmed %v, expected %v", c.Ack, want)
+		}
+	}
+}
+
+func TestPauseOvertakesMoves(t *testing.T) {
+	conn := dial(t)
+	ch := openChannel(t, conn)
+	// Declared like a player's deltas queue.
+	declare(t, ch, "deltas", amqp.Table{"x-max-priority": int32(9), "x-message-ttl": int32(30000)})
+	for _, body := range []string{"move 1", "move 2", "move 3"} {
+		publish(t, ch, "deltas", amqp.Publishing{Body: []byte(body)})
+	}
+	publish(t, ch, "deltas", amqp.Publishing{Body: []byte("pause"), Priority: 9})
+	expectBodies(t, receiveBodies(t, consume(t, ch, "deltas", nil), 4), "pause", "move 1", "move 2", "move 3")
+}
+
+func TestQuorumPriorities(t *testing.T) {
+	conn := dial(t)
+	ch := openChannel(t, conn)
+	declare(t, ch, "commands", amqp.Table{"x-queue-type": "quorum"})
+	for i, priority := range []uint8{0, 9, 3, 5, 4} {
+		publish(t, ch, "commands", amqp.Publishing{Body: []byte{'a' + byte(i)}, Priority: priority})
+	}
+	// Priorities above 4 go first, and messages of the same level keep their order.
+	expectBodies(t, receiveBodies(t, consume(t, ch, "commands", nil), 5), "b", "d", "a", "c", "e")
+
+	_, err := ch.QueueDeclare("prioritised", true, false, false, false, amqp.Table{"x-queue-type": "quorum", "x-max-priority": int32(10)})
+	expectPrecondition(t, err)
+	ch = openChannel(t, conn)
+	_, err = ch.QueueDeclare("exclusive", true, true, false, false, amqp.Table{"x-queue-type": "quorum"})
+	expectPrecondition(t, err)
+}
+
+func TestStreamOffsets(t *testing.T) {
+	conn := dial(t)
+	ch := openChannel(t, conn)
+	declare(t, ch, "deltas", amqp.Table{"x-queue-type": "stream"})
+	for _, body := range []string{"0", "1", "2"} {
+		publish(t, ch, "deltas", amqp.Publishing{Body: []byte(body)})
+	}
+	if err := ch.Qos(10, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		offset any
+		want   []string
+	}{
+		{"first", []string{"0", "1", "2"}},
+		{"last", []string{"2"}},
+		{int64(1), []string{"1", "2"}},
+		{time.Now().Add(-time.Minute), []string{"0", "1", "2"}},
+	}
+	var readers []<-chan amqp.Delivery
+	for _, tt := range tests {
+		deliveries := consume(t, ch, "deltas", amqp.Table{"x-stream-offset": tt.offset})
+		expectBodies(t, receiveBodies(t, deliveries, len(tt.want)), tt.want...)
+		readers = append(readers, deliveries)
+	}
+	next := consume(t, ch, "deltas", amqp.Table{"x-stream-offset": "next"})
+	expectNone(t, next)
+
+	// Every consumer reads the new message, and acks or rejects do not remove it from the stream.
+	publish(t, ch, "deltas", amqp.Publishing{Body: []byte("3")})
+	for _, deliveries := range append(readers, next) {
+		d := receive(t, deliveries)
+		if string(d.Body) != "3" {
+			t.Fatalf("got %q, expected 3", d.Body)
+		}
+		if err := d.Reject(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectBodies(t, receiveBodies(t, consume(t, ch, "deltas", amqp.Table{"x-stream-offset": "first"}), 4), "0", "1", "2", "3")
+}
+
+func TestStreamRetention(t *testing.T) {
+	conn := dial(t)
+	ch := openChannel(t, conn)
+	declare(t, ch, "deltas", amqp.Table{"x-queue-type": "stream", "x-max-length-bytes": int64(10), "x-max-age": "1h"})
+	for _, body := range []string{"aaaaa", "bbbbb", "ccccc"} {
+		publish(t, ch, "deltas", amqp.Publishing{Body: []byte(body)})
+	}
+	if err := ch.Qos(10, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := consume(t, ch, "deltas", amqp.Table{"x-stream-offset": int64(0)})
+	expectBodies(t, receiveBodies(t, deliveries, 2), "bbbbb", "ccccc")
+	expectNone(t, deliveries)
+}
+
+func TestStreamRefusesUnsupportedUse(t *testing.T) {
+	conn := dial(t)
+	tests := []struct {
+		name    string
+		args    amqp.Table
+		durable bool
+	}{
+		{"not durable", amqp.Table{"x-queue-type": "stream"}, false},
+		{"dead letter exchange", amqp.Table{"x-queue-type": "stream", "x-dead-letter-exchange": "peril_dlx"}, true},
+		{"message ttl", amqp.Table{"x-queue-type": "stream", "x-message-ttl": int32(1000)}, true},
+		{"max length", amqp.Table{"x-queue-type": "stream", "x-max-length": int32(10)}, true},
+		{"invalid max age", amqp.Table{"x-queue-type": "stream", "x-max-age": "10"}, true},
+		{"max age of a classic queue", amqp.Table{"x-max-age": "10s"}, true},
+		{"unknown type", amqp.Table{"x-queue-type": "delayed"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := openChannel(t, conn)
+			_, err := ch.QueueDeclare("deltas", tt.durable, false, false, false, tt.args)
+			expectPrecondition(t, err)
+		})
+	}
+
+	ch := openChannel(t, conn)
+	declare(t, ch, "deltas", amqp.Table{"x-queue-type": "stream"})
+	_, err := ch.Consume("deltas", "", false, false, false, false, nil)
+	expectPrecondition(t, err)
+
+	ch = openChannel(t, conn)
+	if err := ch.Qos(10, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ch.Consume("deltas", "", true, false, false, false, nil)
+	expectPrecondition(t, err)
+
+	ch = openChannel(t, conn)
+	_, _, err = ch.Get("deltas", false)
+	expectPrecondition(t, err)
+}
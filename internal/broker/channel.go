@@ -0,0 +1,619 @@
+package broker
+
+import (
+	"sort"
+	"time"
+)
+
+type channel struct {
+	conn *connection
+	id   uint16
+
+	closing   bool
+	consumers map[string]*consumer
+	// Prefetch limits from basic.qos: per consumer for consumers created afterwards, and for the
+	// whole channel when global is set.
+	prefetch       uint16
+	globalPrefetch uint16
+
+	nextTag  uint64
+	unacked  map[uint64]*delivery
+	inFlight int
+
+	confirm    bool
+	publishSeq uint64
+
+	// The message being published, while its content frames arrive.
+	publishing *message
+	gotHeader  bool
+	bodySize   uint64
+}
+
+type consumer struct {
+	tag      string
+	ch       *channel
+	queue    *queue
+	noAck    bool
+	prefetch uint16
+	unacked  int
+	// offset is the next message of a stream the consumer reads.
+	offset int64
+}
+
+type delivery struct {
+	tag      uint64
+	entry    *entry
+	queue    *queue
+	consumer *consumer
+}
+
+func newChannel(c *connection, id uint16) *channel {
+	return &channel{
+		conn:      c,
+		id:        id,
+		consumers: map[string]*consumer{},
+		unacked:   map[uint64]*delivery{},
+	}
+}
+
+func (cons *consumer) ready() bool {
+	ch := cons.ch
+	if ch.closing {
+		return false
+	}
+	if cons.noAck {
+		return true
+	}
+	if cons.prefetch > 0 && cons.unacked >= int(cons.prefetch) {
+		return false
+	}
+	return ch.globalPrefetch == 0 || ch.inFlight < int(ch.globalPrefetch)
+}
+
+func (ch *channel) deliver(cons *consumer, e *entry) {
+	ch.nextTag++
+	tag := ch.nextTag
+	if !cons.noAck {
+		ch.unacked[tag] = &delivery{tag: tag, entry: e, queue: cons.queue, consumer: cons}
+		cons.unacked++
+		ch.inFlight++
+	}
+	m := newMethod(60, 60)
+	m.shortstr(cons.tag)
+	m.longlong(tag)
+	m.bit(e.redelivered)
+	m.shortstr(e.msg.exchange)
+	m.shortstr(e.msg.routingKey)
+	ch.conn.sendContent(ch.id, m, e.msg)
+}
+
+// dropConsumer forgets cons, telling the client with basic.cancel if notify is set.
+func (ch *channel) dropConsumer(cons *consumer, notify bool) {
+	delete(ch.consumers, cons.tag)
+	if notify {
+		m := newMethod(60, 30)
+		m.shortstr(cons.tag)
+		m.bit(true)
+		ch.conn.sendMethod(ch.id, m)
+	}
+}
+
+// settle removes the deliveries covered by tag, in tag order.
+func (ch *channel) settle(tag uint64, multiple bool) ([]*delivery, *amqpError) {
+	var settled []*delivery
+	if multiple {
+		for t, d := range ch.unacked {
+			if tag == 0 || t <= tag {
+				settled = append(settled, d)
+			}
+		}
+		sort.Slice(settled, func(i, j int) bool { return settled[i].tag < settled[j].tag })
+	} else {
+		d, ok := ch.unacked[tag]
+		if !ok {
+			return nil, preconditionFailed("unknown delivery tag %d", tag)
+		}
+		settled = append(settled, d)
+	}
+	for _, d := range settled {
+		delete(ch.unacked, d.tag)
+		if d.consumer != nil {
+			d.consumer.unacked--
+			ch.inFlight--
+		}
+	}
+	return settled, nil
+}
+
+// release requeues or dead-letters settled deliveries, then lets their queues dispatch again.
+func (ch *channel) release(settled []*delivery, acked, requeue bool) {
+	queues := map[*queue]bool{}
+	for _, d := range settled {
+		queues[d.queue] = true
+		switch {
+		case acked:
+		case requeue:
+			d.queue.requeue(d.entry)
+		default:
+			ch.conn.broker.deadLetter(d.queue, d.entry.msg)
+		}
+	}
+	// Capacity freed on this channel may let any of its consumers take more messages.
+	for _, cons := range ch.consumers {
+		queues[cons.queue] = true
+	}
+	for q := range queues {
+		if !q.deleted {
+			q.dispatch()
+		}
+	}
+}
+
+// cleanup cancels the channel's consumers and requeues its unacknowledged messages.
+func (ch *channel) cleanup() {
+	ch.closing = true
+	for _, cons := range ch.consumers {
+		ch.dropConsumer(cons, false)
+		cons.queue.removeConsumer(cons)
+	}
+	settled, _ := ch.settle(0, true)
+	ch.release(settled, false, true)
+}
+
+// fail closes the channel with a channel exception, or returns a connection exception as is.
+func (ch *channel) fail(err *amqpError, method uint32) error {
+	if err.connection {
+		return err
+	}
+	m := newMethod(20, 40)
+	m.short(err.code)
+	m.shortstr(err.text)
+	m.short(uint16(method >> 16))
+	m.short(uint16(method))
+	ch.conn.sendMethod(ch.id, m)
+	ch.cleanup()
+	return nil
+}
+
+func (ch *channel) handleFrame(f frame) error {
+	switch f.kind {
+	case frameMethod:
+		d := &decoder{b: f.payload}
+		id := uint32(d.short())<<16 | uint32(d.short())
+		if ch.closing {
+			// After a channel exception everything but the close handshake is discarded.
+			switch id {
+			case methodChannelClose:
+				ch.conn.sendMethod(ch.id, newMethod(20, 41))
+				delete(ch.conn.channels, ch.id)
+			case methodChannelCloseOk:
+				delete(ch.conn.channels, ch.id)
+			}
+			return nil
+		}
+		if ch.publishing != nil {
+			return unexpectedFrame("expected content for basic.publish on channel %d", ch.id)
+		}
+		if err := ch.handleMethod(id, d); err != nil {
+			return ch.fail(err, id)
+		}
+		return nil
+	case frameHeader:
+		if ch.closing {
+			return nil
+		}
+		return ch.handleHeader(f.payload)
+	case frameBody:
+		if ch.closing {
+			return nil
+		}
+		return ch.handleBody(f.payload)
+	default:
+		return frameError("unknown frame type %d", f.kind)
+	}
+}
+
+func (ch *channel) handleHeader(payload []byte) error {
+	if ch.publishing == nil || ch.gotHeader {
+		return unexpectedFrame("unexpected content header on channel %d", ch.id)
+	}
+	d := &decoder{b: payload}
+	d.short()
+	d.short()
+	ch.bodySize = d.longlong()
+	if d.err != nil {
+		return syntaxError("malformed content header")
+	}
+	props, err := parseProperties(d.b)
+	if err != nil {
+		return syntaxError("malformed content properties: %v", err)
+	}
+	ch.gotHeader = true
+	ch.publishing.properties = d.b
+	ch.publishing.props = props
+	if ch.bodySize == 0 {
+		return ch.completePublish()
+	}
+	return nil
+}
+
+func (ch *channel) handleBody(payload []byte) error {
+	if ch.publishing == nil || !ch.gotHeader {
+		return unexpectedFrame("unexpected content body on channel %d", ch.id)
+	}
+	ch.publishing.body = append(ch.publishing.body, payload...)
+	if uint64(len(ch.publishing.body)) > ch.bodySize {
+		return frameError("content body is larger than its header announced")
+	}
+	if uint64(len(ch.publishing.body)) == ch.bodySize {
+		return ch.completePublish()
+	}
+	return nil
+}
+
+func (ch *channel) completePublish() error {
+	msg := ch.publishing
+	ch.publishing = nil
+	ch.gotHeader = false
+	accepted, err := ch.conn.broker.publish(msg)
+	if err != nil {
+		return ch.fail(err, methodBasicPublish)
+	}
+	if ch.confirm {
+		ch.publishSeq++
+		if accepted {
+			m := newMethod(60, 80)
+			m.longlong(ch.publishSeq)
+			m.bit(false)
+			ch.conn.sendMethod(ch.id, m)
+		} else {
+			m := newMethod(60, 120)
+			m.longlong(ch.publishSeq)
+			m.bit(false)
+			m.bit(false)
+			ch.conn.sendMethod(ch.id, m)
+		}
+	}
+	return nil
+}
+
+func (ch *channel) reply(noWait bool, m *encoder) {
+	if !noWait {
+		ch.conn.sendMethod(ch.id, m)
+	}
+}
+
+func (ch *channel) handleMethod(id uint32, d *decoder) *amqpError {
+	b := ch.conn.broker
+	switch id {
+	case methodChannelClose:
+		ch.cleanup()
+		ch.conn.sendMethod(ch.id, newMethod(20, 41))
+		delete(ch.conn.channels, ch.id)
+		return nil
+
+	case methodChannelCloseOk:
+		return nil
+
+	case methodChannelFlow:
+		active := d.bit()
+		m := newMethod(20, 21)
+		m.bit(active)
+		ch.conn.sendMethod(ch.id, m)
+		return nil
+
+	case methodExchangeDeclare:
+		d.short()
+		name := d.shortstr()
+		kind := d.shortstr()
+		passive, durable, autoDelete, internal, noWait := d.bit(), d.bit(), d.bit(), d.bit(), d.bit()
+		d.table()
+		if d.err != nil {
+			return syntaxError("malformed exchange.declare")
+		}
+		if _, err := b.declareExchange(name, kind, durable, autoDelete, internal, passive); err != nil {
+			return err
+		}
+		ch.reply(noWait, newMethod(40, 11))
+		return nil
+
+	case methodExchangeDelete:
+		d.short()
+		name := d.shortstr()
+		ifUnused, noWait := d.bit(), d.bit()
+		if d.err != nil {
+			return syntaxError("malformed exchange.delete")
+		}
+		if err := b.deleteExchange(name, ifUnused); err != nil {
+			return err
+		}
+		ch.reply(noWait, newMethod(40, 21))
+		return nil
+
+	case methodQueueDeclare:
+		d.short()
+		name := d.shortstr()
+		passive, durable, exclusive, autoDelete, noWait := d.bit(), d.bit(), d.bit(), d.bit(), d.bit()
+		args := d.table()
+		if d.err != nil {
+			return syntaxError("malformed queue.declare")
+		}
+		q, err := b.declareQueue(ch.conn, name, passive, durable, exclusive, autoDelete, args)
+		if err != nil {
+			return err
+		}
+		m := newMethod(50, 11)
+		m.shortstr(q.name)
+		m.long(uint32(len(q.messages)))
+		m.long(uint32(len(q.consumers)))
+		ch.reply(noWait, m)
+		return nil
+
+	case methodQueueBind:
+		d.short()
+		queueName := d.shortstr()
+		exchangeName := d.shortstr()
+		key := d.shortstr()
+		noWait := d.bit()
+		d.table()
+		if d.err != nil {
+			return syntaxError("malformed queue.bind")
+		}
+		if err := b.bind(ch.conn, queueName, exchangeName, key); err != nil {
+			return err
+		}
+		ch.reply(noWait, newMethod(50, 21))
+		return nil
+
+	case methodQueueUnbind:
+		d.short()
+		queueName := d.shortstr()
+		exchangeName := d.shortstr()
+		key := d.shortstr()
+		d.table()
+		if d.err != nil {
+			return syntaxError("malformed queue.unbind")
+		}
+		if err := b.unbind(ch.conn, queueName, exchangeName, key); err != nil {
+			return err
+		}
+		ch.conn.sendMethod(ch.id, newMethod(50, 51))
+		return nil
+
+	case methodQueuePurge:
+		d.short()
+		name := d.shortstr()
+		noWait := d.bit()
+		if d.err != nil {
+			return syntaxError("malformed queue.purge")
+		}
+		q, err := b.lookupQueue(ch.conn, name)
+		if err != nil {
+			return err
+		}
+		if q.queueType == queueTypeStream {
+			return preconditionFailed("queue.purge is not supported by stream queue '%s'", name)
+		}
+		count := len(q.messages)
+		q.messages = nil
+		q.bytes = 0
+		m := newMethod(50, 31)
+		m.long(uint32(count))
+		ch.reply(noWait, m)
+		return nil
+
+	case methodQueueDelete:
+		d.short()
+		name := d.shortstr()
+		ifUnused, ifEmpty, noWait := d.bit(), d.bit(), d.bit()
+		if d.err != nil {
+			return syntaxError("malformed queue.delete")
+		}
+		count := 0
+		if q, ok := b.queues[name]; ok {
+			if err := q.checkAccess(ch.conn); err != nil {
+				return err
+			}
+			if ifUnused && len(q.consumers) > 0 {
+				return preconditionFailed("queue '%s' in use", name)
+			}
+			if ifEmpty && len(q.messages) > 0 {
+				return preconditionFailed("queue '%s' not empty", name)
+			}
+			count = b.deleteQueue(q)
+		}
+		m := newMethod(50, 41)
+		m.long(uint32(count))
+		ch.reply(noWait, m)
+		return nil
+
+	case methodBasicQos:
+		d.long()
+		prefetch := d.short()
+		global := d.bit()
+		if d.err != nil {
+			return syntaxError("malformed basic.qos")
+		}
+		if global {
+			ch.globalPrefetch = prefetch
+		} else {
+			ch.prefetch = prefetch
+		}
+		ch.conn.sendMethod(ch.id, newMethod(60, 11))
+		for _, cons := range ch.consumers {
+			cons.queue.dispatch()
+		}
+		return nil
+
+	case methodBasicConsume:
+		d.short()
+		name := d.shortstr()
+		tag := d.shortstr()
+		_, noAck, exclusive, noWait := d.bit(), d.bit(), d.bit(), d.bit()
+		args := d.table()
+		if d.err != nil {
+			return syntaxError("malformed basic.consume")
+		}
+		q, err := b.lookupQueue(ch.conn, name)
+		if err != nil {
+			return err
+		}
+		if exclusive && len(q.consumers) > 0 {
+			return accessRefused("queue '%s' already has consumers", name)
+		}
+		var offset int64
+		if q.queueType == queueTypeStream {
+			if noAck {
+				return preconditionFailed("stream queue '%s' only supports consumers that acknowledge messages", name)
+			}
+			if ch.prefetch == 0 {
+				return preconditionFailed("consumer prefetch count is not set for stream queue '%s'", name)
+			}
+			if offset, err = q.startOffset(args); err != nil {
+				return err
+			}
+		}
+		if tag == "" {
+			tag = b.generateName("amq.ctag-")
+		}
+		if _, ok := ch.consumers[tag]; ok {
+			return newError(530, true, "NOT_ALLOWED - attempt to reuse consumer tag '%s'", tag)
+		}
+		cons := &consumer{tag: tag, ch: ch, queue: q, noAck: noAck, prefetch: ch.prefetch, offset: offset}
+		ch.consumers[tag] = cons
+		q.addConsumer(cons)
+		m := newMethod(60, 21)
+		m.shortstr(tag)
+		ch.reply(noWait, m)
+		q.dispatch()
+		return nil
+
+	case methodBasicCancel:
+		tag := d.shortstr()
+		noWait := d.bit()
+		if d.err != nil {
+			return syntaxError("malformed basic.cancel")
+		}
+		if cons, ok := ch.consumers[tag]; ok {
+			ch.dropConsumer(cons, false)
+			cons.queue.removeConsumer(cons)
+		}
+		m := newMethod(60, 31)
+		m.shortstr(tag)
+		ch.reply(noWait, m)
+		return nil
+
+	case methodBasicPublish:
+		d.short()
+		exchangeName := d.shortstr()
+		key := d.shortstr()
+		d.bit()
+		d.bit()
+		if d.err != nil {
+			return syntaxError("malformed basic.publish")
+		}
+		ex, ok := b.exchanges[exchangeName]
+		if !ok {
+			return notFound("no exchange '%s'", exchangeName)
+		}
+		if ex.internal {
+			return accessRefused("cannot publish to internal exchange '%s'", exchangeName)
+		}
+		ch.publishing = &message{exchange: exchangeName, routingKey: key}
+		return nil
+
+	case methodBasicGet:
+		d.short()
+		name := d.shortstr()
+		noAck := d.bit()
+		if d.err != nil {
+			return syntaxError("malformed basic.get")
+		}
+		q, err := b.lookupQueue(ch.conn, name)
+		if err != nil {
+			return err
+		}
+		if q.queueType == queueTypeStream {
+			return preconditionFailed("basic.get is not supported by stream queue '%s'", name)
+		}
+		q.expire(time.Now())
+		if len(q.messages) == 0 {
+			m := newMethod(60, 72)
+			m.shortstr("")
+			ch.conn.sendMethod(ch.id, m)
+			return nil
+		}
+		e := q.removeHead()
+		e.deliveries++
+		ch.nextTag++
+		if !noAck {
+			ch.unacked[ch.nextTag] = &delivery{tag: ch.nextTag, entry: e, queue: q}
+		}
+		m := newMethod(60, 71)
+		m.longlong(ch.nextTag)
+		m.bit(e.redelivered)
+		m.shortstr(e.msg.exchange)
+		m.shortstr(e.msg.routingKey)
+		m.long(uint32(len(q.messages)))
+		ch.conn.sendContent(ch.id, m, e.msg)
+		return nil
+
+	case methodBasicAck:
+		tag := d.longlong()
+		multiple := d.bit()
+		if d.err != nil {
+			return syntaxError("malformed basic.ack")
+		}
+		settled, err := ch.settle(tag, multiple)
+		if err != nil {
+			return err
+		}
+		ch.release(settled, true, false)
+		return nil
+
+	case methodBasicNack:
+		tag := d.longlong()
+		multiple, requeue := d.bit(), d.bit()
+		if d.err != nil {
+			return syntaxError("malformed basic.nack")
+		}
+		settled, err := ch.settle(tag, multiple)
+		if err != nil {
+			return err
+		}
+		ch.release(settled, false, requeue)
+		return nil
+
+	case methodBasicReject:
+		tag := d.longlong()
+		requeue := d.bit()
+		if d.err != nil {
+			return syntaxError("malformed basic.reject")
+		}
+		settled, err := ch.settle(tag, false)
+		if err != nil {
+			return err
+		}
+		ch.release(settled, false, requeue)
+		return nil
+
+	case methodBasicRecover:
+		requeue := d.bit()
+		if !requeue {
+			return notImplemented("basic.recover without requeue")
+		}
+		settled, _ := ch.settle(0, true)
+		ch.release(settled, false, true)
+		ch.conn.sendMethod(ch.id, newMethod(60, 111))
+		return nil
+
+	case methodConfirmSelect:
+		noWait := d.bit()
+		ch.confirm = true
+		ch.reply(noWait, newMethod(85, 11))
+		return nil
+
+	default:
+		return notImplemented("method %d.%d", id>>16, id&0xffff)
+	}
+}
@@ -0,0 +1,391 @@
+package broker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limits the broker proposes while tuning a connection.
+const (
+	channelMax       = 2047
+	frameMax         = 131072
+	heartbeatSeconds = 60
+	handshakeTimeout = 10 * time.Second
+)
+
+// Method identifiers, as class<<16 | method.
+const (
+	methodConnectionStart   = 10<<16 | 10
+	methodConnectionStartOk = 10<<16 | 11
+	methodConnectionTune    = 10<<16 | 30
+	methodConnectionTuneOk  = 10<<16 | 31
+	methodConnectionOpen    = 10<<16 | 40
+	methodConnectionOpenOk  = 10<<16 | 41
+	methodConnectionClose   = 10<<16 | 50
+	methodConnectionCloseOk = 10<<16 | 51
+
+	methodChannelOpen    = 20<<16 | 10
+	methodChannelOpenOk  = 20<<16 | 11
+	methodChannelFlow    = 20<<16 | 20
+	methodChannelFlowOk  = 20<<16 | 21
+	methodChannelClose   = 20<<16 | 40
+	methodChannelCloseOk = 20<<16 | 41
+
+	methodExchangeDeclare   = 40<<16 | 10
+	methodExchangeDeclareOk = 40<<16 | 11
+	methodExchangeDelete    = 40<<16 | 20
+	methodExchangeDeleteOk  = 40<<16 | 21
+
+	methodQueueDeclare   = 50<<16 | 10
+	methodQueueDeclareOk = 50<<16 | 11
+	methodQueueBind      = 50<<16 | 20
+	methodQueueBindOk    = 50<<16 | 21
+	methodQueuePurge     = 50<<16 | 30
+	methodQueuePurgeOk   = 50<<16 | 31
+	methodQueueDelete    = 50<<16 | 40
+	methodQueueDeleteOk  = 50<<16 | 41
+	methodQueueUnbind    = 50<<16 | 50
+	methodQueueUnbindOk  = 50<<16 | 51
+
+	methodBasicQos       = 60<<16 | 10
+	methodBasicQosOk     = 60<<16 | 11
+	methodBasicConsume   = 60<<16 | 20
+	methodBasicConsumeOk = 60<<16 | 21
+	methodBasicCancel    = 60<<16 | 30
+	methodBasicCancelOk  = 60<<16 | 31
+	methodBasicPublish   = 60<<16 | 40
+	methodBasicDeliver   = 60<<16 | 60
+	methodBasicGet       = 60<<16 | 70
+	methodBasicGetOk     = 60<<16 | 71
+	methodBasicGetEmpty  = 60<<16 | 72
+	methodBasicAck       = 60<<16 | 80
+	methodBasicReject    = 60<<16 | 90
+	methodBasicRecover   = 60<<16 | 110
+	methodBasicRecoverOk = 60<<16 | 111
+	methodBasicNack      = 60<<16 | 120
+
+	methodConfirmSelect   = 85<<16 | 10
+	methodConfirmSelectOk = 85<<16 | 11
+)
+
+const classBasic = 60
+
+var errConnectionClosed = errors.New("connection closed by client")
+
+type connection struct {
+	broker    *Broker
+	conn      net.Conn
+	r         *bufio.Reader
+	frameMax  uint32
+	heartbeat time.Duration
+
+	outMu     sync.Mutex
+	outCond   *sync.Cond
+	out       []byte
+	outClosed bool
+
+	// Guarded by the broker lock.
+	channels map[uint16]*channel
+}
+
+func newConnection(b *Broker, conn net.Conn) *connection {
+	c := &connection{
+		broker:   b,
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		frameMax: frameMax,
+		channels: map[uint16]*channel{},
+	}
+	c.outCond = sync.NewCond(&c.outMu)
+	return c
+}
+
+func (c *connection) send(f frame) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.outClosed {
+		return
+	}
+	c.out = appendFrame(c.out, f)
+	c.outCond.Signal()
+}
+
+func (c *connection) sendMethod(channel uint16, m *encoder) {
+	c.send(frame{kind: frameMethod, channel: channel, payload: m.b})
+}
+
+// sendContent sends a method followed by the message's content header and body frames.
+func (c *connection) sendContent(channel uint16, m *encoder, msg *message) {
+	header := &encoder{}
+	header.short(classBasic)
+	header.short(0)
+	header.longlong(uint64(len(msg.body)))
+	header.b = append(header.b, msg.properties...)
+
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.outClosed {
+		return
+	}
+	c.out = appendFrame(c.out, frame{kind: frameMethod, channel: channel, payload: m.b})
+	c.out = appendFrame(c.out, frame{kind: frameHeader, channel: channel, payload: header.b})
+	maxBody := int(c.frameMax) - frameOverhead
+	for body := msg.body; len(body) > 0; {
+		n := min(len(body), maxBody)
+		c.out = appendFrame(c.out, frame{kind: frameBody, channel: channel, payload: body[:n]})
+		body = body[n:]
+	}
+	c.outCond.Signal()
+}
+
+// writeLoop writes queued frames until the outbox is closed and drained.
+func (c *connection) writeLoop(done chan<- struct{}) {
+	defer close(done)
+	for {
+		c.outMu.Lock()
+		for len(c.out) == 0 && !c.outClosed {
+			c.outCond.Wait()
+		}
+		if len(c.out) == 0 && c.outClosed {
+			c.outMu.Unlock()
+			return
+		}
+		data := c.out
+		c.out = nil
+		c.outMu.Unlock()
+
+		if _, err := c.conn.Write(data); err != nil {
+			c.conn.Close()
+			c.closeOutbox()
+			return
+		}
+	}
+}
+
+func (c *connection) closeOutbox() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outClosed = true
+	c.out = nil
+	c.outCond.Signal()
+}
+
+// finishOutbox lets the writer send what is queued and then stop.
+func (c *connection) finishOutbox() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outClosed = true
+	c.outCond.Signal()
+}
+
+func (c *connection) heartbeatLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(c.heartbeat / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.send(frame{kind: frameHeartbeat})
+		case <-done:
+			return
+		}
+	}
+}
+
+func (c *connection) serve() error {
+	defer c.conn.Close()
+	writerDone := make(chan struct{})
+	go c.writeLoop(writerDone)
+	defer func() {
+		c.finishOutbox()
+		<-writerDone
+	}()
+
+	if err := c.handshake(); err != nil {
+		return err
+	}
+	if c.heartbeat > 0 {
+		go c.heartbeatLoop(writerDone)
+	}
+
+	for {
+		if c.heartbeat > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(3 * c.heartbeat))
+		}
+		f, err := readFrame(c.r, c.frameMax)
+		if err != nil {
+			return err
+		}
+
+		c.broker.mu.Lock()
+		err = c.handleFrame(f)
+		c.broker.mu.Unlock()
+
+		var amqpErr *amqpError
+		if errors.As(err, &amqpErr) {
+			c.closeWith(amqpErr, 0)
+			return fmt.Errorf("closed with %d %s", amqpErr.code, amqpErr.text)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// closeWith sends Connection.Close for a connection exception.
+func (c *connection) closeWith(err *amqpError, method uint32) {
+	m := newMethod(10, 50)
+	m.short(err.code)
+	m.shortstr(err.text)
+	m.short(uint16(method >> 16))
+	m.short(uint16(method))
+	c.sendMethod(0, m)
+}
+
+func (c *connection) readMethod(expected uint32) (*decoder, error) {
+	f, err := readFrame(c.r, c.frameMax)
+	if err != nil {
+		return nil, err
+	}
+	if f.kind != frameMethod || f.channel != 0 {
+		return nil, fmt.Errorf("expected a method frame on channel 0 during the handshake")
+	}
+	d := &decoder{b: f.payload}
+	if id := uint32(d.short())<<16 | uint32(d.short()); id != expected {
+		return nil, fmt.Errorf("expected method %d.%d during the handshake, got %d.%d", expected>>16, expected&0xffff, id>>16, id&0xffff)
+	}
+	return d, nil
+}
+
+func (c *connection) handshake() error {
+	c.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	header := make([]byte, len(protocolHeader))
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return err
+	}
+	if string(header) != string(protocolHeader) {
+		c.conn.Write(protocolHeader)
+		return errors.New("unsupported protocol")
+	}
+
+	start := newMethod(10, 10)
+	start.octet(0)
+	start.octet(9)
+	start.table(Table{
+		"product":  "peril-broker",
+		"platform": "Go",
+		"capabilities": Table{
+			"publisher_confirms":         true,
+			"basic.nack":                 true,
+			"consumer_cancel_notify":     true,
+			"per_consumer_qos":           true,
+			"exchange_exchange_bindings": false,
+		},
+	})
+	start.longstr([]byte("PLAIN AMQPLAIN"))
+	start.longstr([]byte("en_US"))
+	c.sendMethod(0, start)
+
+	d, err := c.readMethod(methodConnectionStartOk)
+	if err != nil {
+		return err
+	}
+	d.table()
+	mechanism := d.shortstr()
+	if d.err != nil {
+		return d.err
+	}
+	if mechanism != "PLAIN" && mechanism != "AMQPLAIN" {
+		return fmt.Errorf("unsupported authentication mechanism %s", mechanism)
+	}
+
+	tune := newMethod(10, 30)
+	tune.short(channelMax)
+	tune.long(frameMax)
+	tune.short(heartbeatSeconds)
+	c.sendMethod(0, tune)
+
+	d, err = c.readMethod(methodConnectionTuneOk)
+	if err != nil {
+		return err
+	}
+	d.short()
+	clientFrameMax := d.long()
+	heartbeat := d.short()
+	if d.err != nil {
+		return d.err
+	}
+	if clientFrameMax > 0 && clientFrameMax < frameMax {
+		c.frameMax = clientFrameMax
+	}
+	c.heartbeat = time.Duration(heartbeat) * time.Second
+
+	if _, err := c.readMethod(methodConnectionOpen); err != nil {
+		return err
+	}
+	openOk := newMethod(10, 41)
+	openOk.shortstr("")
+	c.sendMethod(0, openOk)
+	return nil
+}
+
+func (c *connection) handleFrame(f frame) error {
+	if f.kind == frameHeartbeat {
+		return nil
+	}
+	if f.channel == 0 {
+		if f.kind != frameMethod {
+			return unexpectedFrame("content frame on channel 0")
+		}
+		d := &decoder{b: f.payload}
+		switch id := uint32(d.short())<<16 | uint32(d.short()); id {
+		case methodConnectionClose:
+			c.sendMethod(0, newMethod(10, 51))
+			return errConnectionClosed
+		case methodConnectionCloseOk:
+			return errConnectionClosed
+		default:
+			return commandInvalid("unexpected method %d.%d on channel 0", id>>16, id&0xffff)
+		}
+	}
+
+	ch, ok := c.channels[f.channel]
+	if !ok {
+		if f.kind != frameMethod {
+			return channelError("content frame on unopened channel %d", f.channel)
+		}
+		d := &decoder{b: f.payload}
+		if id := uint32(d.short())<<16 | uint32(d.short()); id != methodChannelOpen {
+			return channelError("expected channel.open on channel %d", f.channel)
+		}
+		if f.channel > channelMax {
+			return channelError("channel %d is above the negotiated maximum", f.channel)
+		}
+		c.channels[f.channel] = newChannel(c, f.channel)
+		openOk := newMethod(20, 11)
+		openOk.longstr(nil)
+		c.sendMethod(f.channel, openOk)
+		return nil
+	}
+	return ch.handleFrame(f)
+}
+
+// cleanup releases everything the connection held once it is gone. It runs under the broker lock.
+func (c *connection) cleanup() {
+	c.closeOutbox()
+	for id, ch := range c.channels {
+		ch.cleanup()
+		delete(c.channels, id)
+	}
+	for _, q := range c.broker.queues {
+		if q.exclusive && q.owner == c {
+			c.broker.deleteQueue(q)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package broker
+
+import "fmt"
+
+// Reply codes of AMQP 0-9-1 exceptions.
+const (
+	replySuccess            = 200
+	replyAccessRefused      = 403
+	replyNotFound           = 404
+	replyResourceLocked     = 405
+	replyPreconditionFailed = 406
+	replyFrameError         = 501
+	replySyntaxError        = 502
+	replyCommandInvalid     = 503
+	replyChannelError       = 504
+	replyUnexpectedFrame    = 505
+	replyNotImplemented     = 540
+)
+
+// amqpError is an exception that closes the channel, or the whole connection if connection is set.
+type amqpError struct {
+	code       uint16
+	text       string
+	connection bool
+}
+
+func newError(code uint16, connection bool, format string, args ...any) *amqpError {
+	return &amqpError{code: code, text: fmt.Sprintf(format, args...), connection: connection}
+}
+
+func accessRefused(format string, args ...any) *amqpError {
+	return newError(replyAccessRefused, false, "ACCESS_REFUSED - "+format, args...)
+}
+
+func notFound(format string, args ...any) *amqpError {
+	return newError(replyNotFound, false, "NOT_FOUND - "+format, args...)
+}
+
+func resourceLocked(format string, args ...any) *amqpError {
+	return newError(replyResourceLocked, false, "RESOURCE_LOCKED - "+format, args...)
+}
+
+func preconditionFailed(format string, args ...any) *amqpError {
+	return newError(replyPreconditionFailed, false, "PRECONDITION_FAILED - "+format, args...)
+}
+
+func commandInvalid(format string, args ...any) *amqpError {
+	return newError(replyCommandInvalid, true, "COMMAND_INVALID - "+format, args...)
+}
+
+func channelError(format string, args ...any) *amqpError {
+	return newError(replyChannelError, true, "CHANNEL_ERROR - "+format, args...)
+}
+
+func unexpectedFrame(format string, args ...any) *amqpError {
+	return newError(replyUnexpectedFrame, true, "UNEXPECTED_FRAME - "+format, args...)
+}
+
+func syntaxError(format string, args ...any) *amqpError {
+	return newError(replySyntaxError, true, "SYNTAX_ERROR - "+format, args...)
+}
+
+func frameError(format string, args ...any) *amqpError {
+	return newError(replyFrameError, true, "FRAME_ERROR - "+format, args...)
+}
+
+func notImplemented(format string, args ...any) *amqpError {
+	return newError(replyNotImplemented, true, "NOT_IMPLEMENTED - "+format, args...)
+}
+
+func (e *amqpError) Error() string {
+	return fmt.Sprintf("%d %s", e.code, e.text)
+}
@@ -0,0 +1,493 @@
+package broker
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Overflow policies of queues with a length limit.
+const (
+	overflowDropHead         = "drop-head"
+	overflowRejectPublish    = "reject-publish"
+	overflowRejectPublishDLX = "reject-publish-dlx"
+)
+
+// Queue types. Quorum queues behave like classic ones on a single node, apart from the delivery
+// limit and their two priorities.
+const (
+	queueTypeClassic = "classic"
+	queueTypeQuorum  = "quorum"
+	queueTypeStream  = "stream"
+)
+
+// Quorum queues deliver messages with a priority above quorumHighPriority first and do not tell
+// the other priorities apart.
+const quorumHighPriority = 4
+
+type queue struct {
+	broker     *Broker
+	name       string
+	durable    bool
+	exclusive  bool
+	autoDelete bool
+	owner      *connection
+	deleted    bool
+	queueType  string
+
+	deadLetterExchange   *string
+	deadLetterRoutingKey string
+	messageTTL           time.Duration
+	maxLength            int
+	maxLengthBytes       int64
+	overflow             string
+	maxPriority          uint8
+	deliveryLimit        int
+	maxAge               time.Duration
+
+	// messages are the ready messages, highest priority first.
+	messages     []*entry
+	bytes        int64
+	consumers    []*consumer
+	nextConsumer int
+	hadConsumers bool
+
+	// log holds the messages of a stream, oldest first, from logOffset on. Streams keep messages
+	// after delivery, and every consumer reads the log from its own offset.
+	log       []*entry
+	logOffset int64
+}
+
+// entry is a message sitting in, or delivered from, a particular queue.
+type entry struct {
+	msg         *message
+	priority    uint8
+	expiresAt   time.Time
+	redelivered bool
+	deliveries  int
+	// publishedAt is when the message was appended to a stream.
+	publishedAt time.Time
+}
+
+func intArg(args Table, name string) (int64, bool, *amqpError) {
+	value, ok := args[name]
+	if !ok {
+		return 0, false, nil
+	}
+	var n int64
+	switch v := value.(type) {
+	case int8:
+		n = int64(v)
+	case uint8:
+		n = int64(v)
+	case int16:
+		n = int64(v)
+	case uint16:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case uint32:
+		n = int64(v)
+	case int64:
+		n = v
+	default:
+		return 0, false, preconditionFailed("invalid arg '%s' for queue: expected an integer, got %T", name, value)
+	}
+	if n < 0 {
+		return 0, false, preconditionFailed("invalid arg '%s' for queue: must not be negative", name)
+	}
+	return n, true, nil
+}
+
+func stringArg(args Table, name string) (string, bool, *amqpError) {
+	value, ok := args[name]
+	if !ok {
+		return "", false, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true, nil
+	case []byte:
+		return string(v), true, nil
+	default:
+		return "", false, preconditionFailed("invalid arg '%s' for queue: expected a string, got %T", name, value)
+	}
+}
+
+func newQueue(b *Broker, name string, durable, exclusive, autoDelete bool, args Table) (*queue, *amqpError) {
+	q := &queue{
+		broker:     b,
+		name:       name,
+		durable:    durable,
+		exclusive:  exclusive,
+		autoDelete: autoDelete,
+		maxLength:  -1,
+		overflow:   overflowDropHead,
+		queueType:  queueTypeClassic,
+	}
+
+	queueType, ok, err := stringArg(args, "x-queue-type")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		q.queueType = queueType
+	}
+	switch q.queueType {
+	case queueTypeClassic:
+	case queueTypeQuorum, queueTypeStream:
+		if !durable || exclusive || autoDelete {
+			return nil, preconditionFailed("%s queue '%s' must be durable, non-exclusive and not auto-delete", q.queueType, name)
+		}
+	default:
+		return nil, preconditionFailed("queue type %s is not supported", queueType)
+	}
+	if q.queueType == queueTypeStream {
+		// Streams only limit how long and how much they keep, and never dead-letter.
+		for _, arg := range []string{"x-dead-letter-exchange", "x-dead-letter-routing-key", "x-message-ttl", "x-max-length", "x-overflow", "x-max-priority"} {
+			if _, ok := args[arg]; ok {
+				return nil, preconditionFailed("invalid arg '%s' for queue: stream queues do not support it", arg)
+			}
+		}
+	}
+
+	if dlx, ok, err := stringArg(args, "x-dead-letter-exchange"); err != nil {
+		return nil, err
+	} else if ok {
+		q.deadLetterExchange = &dlx
+	}
+	if q.deadLetterRoutingKey, _, err = stringArg(args, "x-dead-letter-routing-key"); err != nil {
+		return nil, err
+	}
+	if ttl, ok, err := intArg(args, "x-message-ttl"); err != nil {
+		return nil, err
+	} else if ok {
+		q.messageTTL = time.Duration(ttl) * time.Millisecond
+		if ttl == 0 {
+			q.messageTTL = time.Nanosecond
+		}
+	}
+	if length, ok, err := intArg(args, "x-max-length"); err != nil {
+		return nil, err
+	} else if ok {
+		q.maxLength = int(length)
+	}
+	if length, ok, err := intArg(args, "x-max-length-bytes"); err != nil {
+		return nil, err
+	} else if ok {
+		q.maxLengthBytes = length
+	} else {
+		q.maxLengthBytes = -1
+	}
+	if overflow, ok, err := stringArg(args, "x-overflow"); err != nil {
+		return nil, err
+	} else if ok {
+		switch overflow {
+		case overflowDropHead, overflowRejectPublish, overflowRejectPublishDLX:
+			q.overflow = overflow
+		default:
+			return nil, preconditionFailed("invalid arg 'x-overflow' for queue: %s", overflow)
+		}
+	}
+	if priority, ok, err := intArg(args, "x-max-priority"); err != nil {
+		return nil, err
+	} else if ok {
+		if q.queueType == queueTypeQuorum {
+			return nil, preconditionFailed("invalid arg 'x-max-priority' for queue: quorum queues always have a normal and a high priority")
+		}
+		if priority > 255 {
+			return nil, preconditionFailed("invalid arg 'x-max-priority' for queue: must be at most 255")
+		}
+		q.maxPriority = uint8(priority)
+	}
+	if limit, ok, err := intArg(args, "x-delivery-limit"); err != nil {
+		return nil, err
+	} else if ok {
+		if q.queueType != queueTypeQuorum {
+			return nil, preconditionFailed("invalid arg 'x-delivery-limit' for queue: only quorum queues support it")
+		}
+		q.deliveryLimit = int(limit)
+	}
+	if maxAge, ok, err := stringArg(args, "x-max-age"); err != nil {
+		return nil, err
+	} else if ok {
+		if q.queueType != queueTypeStream {
+			return nil, preconditionFailed("invalid arg 'x-max-age' for queue: only stream queues support it")
+		}
+		if q.maxAge, ok = parseMaxAge(maxAge); !ok {
+			return nil, preconditionFailed("invalid arg 'x-max-age' for queue: %s", maxAge)
+		}
+	}
+	return q, nil
+}
+
+// parseMaxAge parses the x-max-age of a stream: a positive number followed by one of the units
+// Y, M, D, h, m or s.
+func parseMaxAge(s string) (time.Duration, bool) {
+	units := map[byte]time.Duration{
+		'Y': 365 * 24 * time.Hour,
+		'M': 30 * 24 * time.Hour,
+		'D': 24 * time.Hour,
+		'h': time.Hour,
+		'm': time.Minute,
+		's': time.Second,
+	}
+	if len(s) < 2 {
+		return 0, false
+	}
+	unit, ok := units[s[len(s)-1]]
+	n, err := strconv.ParseUint(s[:len(s)-1], 10, 32)
+	if !ok || err != nil || n == 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+func (q *queue) String() string {
+	return fmt.Sprintf("queue '%s'", q.name)
+}
+
+func (q *queue) checkAccess(owner *connection) *amqpError {
+	if q.exclusive && q.owner != owner {
+		return resourceLocked("cannot obtain exclusive access to locked queue '%s'", q.name)
+	}
+	return nil
+}
+
+// wouldOverflow reports whether one more message of size bytes goes past the length limits.
+func (q *queue) wouldOverflow(size int) bool {
+	if q.maxLength >= 0 && len(q.messages)+1 > q.maxLength {
+		return true
+	}
+	return q.maxLengthBytes >= 0 && q.bytes+int64(size) > q.maxLengthBytes
+}
+
+func (q *queue) overLimit() bool {
+	if q.maxLength >= 0 && len(q.messages) > q.maxLength {
+		return true
+	}
+	return q.maxLengthBytes >= 0 && q.bytes > q.maxLengthBytes
+}
+
+// enqueue adds a newly published message. It returns false if the overflow policy refused it.
+func (q *queue) enqueue(msg *message) bool {
+	if q.deleted {
+		return true
+	}
+	if q.queueType == queueTypeStream {
+		q.append(msg)
+		return true
+	}
+	if q.wouldOverflow(len(msg.body)) {
+		switch q.overflow {
+		case overflowRejectPublish:
+			return false
+		case overflowRejectPublishDLX:
+			q.broker.deadLetter(q, msg)
+			return false
+		}
+	}
+
+	e := &entry{msg: msg, priority: q.priorityOf(msg.props.priority)}
+	ttl := msg.props.expiration
+	if q.messageTTL > 0 && (ttl == 0 || q.messageTTL < ttl) {
+		ttl = q.messageTTL
+	}
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+	q.insert(e, false)
+
+	for q.overLimit() && len(q.messages) > 0 {
+		q.broker.deadLetter(q, q.removeHead().msg)
+	}
+	q.dispatch()
+	return true
+}
+
+// priorityOf is the priority a message published with the given priority is queued with.
+func (q *queue) priorityOf(priority uint8) uint8 {
+	if q.queueType == queueTypeQuorum {
+		if priority > quorumHighPriority {
+			return 1
+		}
+		return 0
+	}
+	return min(priority, q.maxPriority)
+}
+
+// append adds a newly published message to the end of a stream.
+func (q *queue) append(msg *message) {
+	now := time.Now()
+	q.log = append(q.log, &entry{msg: msg, publishedAt: now})
+	q.bytes += int64(len(msg.body))
+	q.trim(now)
+	q.dispatch()
+}
+
+// trim drops the oldest messages of a stream that are past its retention limits.
+func (q *queue) trim(now time.Time) {
+	for len(q.log) > 0 {
+		head := q.log[0]
+		tooOld := q.maxAge > 0 && !head.publishedAt.Add(q.maxAge).After(now)
+		tooBig := q.maxLengthBytes >= 0 && q.bytes > q.maxLengthBytes
+		if !tooOld && !tooBig {
+			return
+		}
+		q.log[0] = nil
+		q.log = q.log[1:]
+		q.logOffset++
+		q.bytes -= int64(len(head.msg.body))
+	}
+}
+
+// logEnd is the offset the next message appended to a stream gets.
+func (q *queue) logEnd() int64 {
+	return q.logOffset + int64(len(q.log))
+}
+
+// startOffset is where a new consumer of a stream starts reading, given its x-stream-offset
+// argument: first, last, next, an offset or a timestamp. Consumers start with the next message
+// by default.
+func (q *queue) startOffset(args Table) (int64, *amqpError) {
+	value, ok := args["x-stream-offset"]
+	if !ok {
+		return q.logEnd(), nil
+	}
+	switch v := value.(type) {
+	case string:
+		switch v {
+		case "first":
+			return q.logOffset, nil
+		case "last":
+			return max(q.logEnd()-1, q.logOffset), nil
+		case "next":
+			return q.logEnd(), nil
+		}
+		return 0, preconditionFailed("invalid arg 'x-stream-offset' for consumer: %s", v)
+	case time.Time:
+		for i, e := range q.log {
+			if !e.publishedAt.Before(v) {
+				return q.logOffset + int64(i), nil
+			}
+		}
+		return q.logEnd(), nil
+	}
+	offset, _, err := intArg(args, "x-stream-offset")
+	if err != nil {
+		return 0, err
+	}
+	return min(max(offset, q.logOffset), q.logEnd()), nil
+}
+
+// insert puts e behind the messages of the same priority, or in front of them if it is requeued.
+func (q *queue) insert(e *entry, front bool) {
+	i := len(q.messages)
+	for j, other := range q.messages {
+		if other.priority < e.priority || (front && other.priority == e.priority) {
+			i = j
+			break
+		}
+	}
+	q.messages = append(q.messages, nil)
+	copy(q.messages[i+1:], q.messages[i:])
+	q.messages[i] = e
+	q.bytes += int64(len(e.msg.body))
+}
+
+func (q *queue) removeHead() *entry {
+	e := q.messages[0]
+	q.messages[0] = nil
+	q.messages = q.messages[1:]
+	q.bytes -= int64(len(e.msg.body))
+	return e
+}
+
+// requeue puts a delivered message back, or dead-letters it once it has used up its delivery limit.
+func (q *queue) requeue(e *entry) {
+	// A stream keeps every message in its log, and a consumer has read past the ones it rejects.
+	if q.deleted || q.queueType == queueTypeStream {
+		return
+	}
+	if q.deliveryLimit > 0 && e.deliveries > q.deliveryLimit {
+		q.broker.deadLetter(q, e.msg)
+		return
+	}
+	e.redelivered = true
+	q.insert(e, true)
+}
+
+// expire dead-letters expired messages at the head of the queue.
+func (q *queue) expire(now time.Time) {
+	if q.queueType == queueTypeStream {
+		q.trim(now)
+		return
+	}
+	for len(q.messages) > 0 {
+		head := q.messages[0]
+		if head.expiresAt.IsZero() || head.expiresAt.After(now) {
+			return
+		}
+		q.removeHead()
+		q.broker.deadLetter(q, head.msg)
+	}
+}
+
+// dispatch hands ready messages to consumers with spare prefetch capacity, round robin.
+func (q *queue) dispatch() {
+	q.expire(time.Now())
+	if q.queueType == queueTypeStream {
+		q.dispatchStream()
+		return
+	}
+	for len(q.messages) > 0 {
+		cons := q.readyConsumer()
+		if cons == nil {
+			return
+		}
+		e := q.removeHead()
+		e.deliveries++
+		cons.ch.deliver(cons, e)
+	}
+}
+
+// dispatchStream hands every consumer of a stream the messages it has not read yet.
+func (q *queue) dispatchStream() {
+	for _, cons := range q.consumers {
+		cons.offset = max(cons.offset, q.logOffset)
+		for cons.offset < q.logEnd() && cons.ready() {
+			e := q.log[cons.offset-q.logOffset]
+			cons.offset++
+			cons.ch.deliver(cons, e)
+		}
+	}
+}
+
+func (q *queue) readyConsumer() *consumer {
+	for i := 0; i < len(q.consumers); i++ {
+		cons := q.consumers[(q.nextConsumer+i)%len(q.consumers)]
+		if cons.ready() {
+			q.nextConsumer = (q.nextConsumer + i + 1) % len(q.consumers)
+			return cons
+		}
+	}
+	return nil
+}
+
+func (q *queue) addConsumer(cons *consumer) {
+	q.consumers = append(q.consumers, cons)
+	q.hadConsumers = true
+}
+
+// removeConsumer drops cons and deletes an auto-delete queue once its last consumer is gone.
+func (q *queue) removeConsumer(cons *consumer) {
+	for i, c := range q.consumers {
+		if c == cons {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.autoDelete && q.hadConsumers && len(q.consumers) == 0 && !q.deleted {
+		q.broker.deleteQueue(q)
+	}
+}
@@ -0,0 +1,376 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Frame types of AMQP 0-9-1.
+const (
+	frameMethod    byte = 1
+	frameHeader    byte = 2
+	frameBody      byte = 3
+	frameHeartbeat byte = 8
+	frameEnd       byte = 0xce
+)
+
+// Size of the frame header and end marker around a frame payload.
+const frameOverhead = 8
+
+var protocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+type frame struct {
+	kind    byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader, frameMax uint32) (frame, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	size := binary.BigEndian.Uint32(header[3:])
+	if frameMax > 0 && size+frameOverhead > frameMax {
+		return frame{}, fmt.Errorf("frame of %d bytes exceeds the negotiated maximum", size)
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return frame{}, err
+	}
+	if payload[size] != frameEnd {
+		return frame{}, errors.New("frame is not terminated by the frame end marker")
+	}
+	return frame{
+		kind:    header[0],
+		channel: binary.BigEndian.Uint16(header[1:]),
+		payload: payload[:size],
+	}, nil
+}
+
+func appendFrame(buf []byte, f frame) []byte {
+	buf = append(buf, f.kind)
+	buf = binary.BigEndian.AppendUint16(buf, f.channel)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(f.payload)))
+	buf = append(buf, f.payload...)
+	return append(buf, frameEnd)
+}
+
+// Table is an AMQP field table, decoded into the same Go types amqp091-go uses.
+type Table map[string]any
+
+type Decimal struct {
+	Scale uint8
+	Value int32
+}
+
+// decoder reads method arguments. The first error sticks and turns every later read into a no-op.
+type decoder struct {
+	b      []byte
+	err    error
+	bits   byte
+	bitPos int
+}
+
+var errTruncated = errors.New("truncated method arguments")
+
+func (d *decoder) take(n int) []byte {
+	d.bitPos = 0
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < n {
+		d.err = errTruncated
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) octet() byte {
+	if v := d.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *decoder) short() uint16 {
+	if v := d.take(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (d *decoder) long() uint32 {
+	if v := d.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) longlong() uint64 {
+	if v := d.take(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (d *decoder) shortstr() string {
+	n := int(d.octet())
+	return string(d.take(n))
+}
+
+func (d *decoder) longstr() []byte {
+	n := int(d.long())
+	return d.take(n)
+}
+
+// bit reads the next of a run of bit arguments, which are packed into octets.
+func (d *decoder) bit() bool {
+	if d.bitPos == 0 || d.bitPos == 8 {
+		d.bits = d.octet()
+	}
+	v := d.bits&(1<<d.bitPos) != 0
+	d.bitPos++
+	return v
+}
+
+func (d *decoder) table() Table {
+	data := d.longstr()
+	if d.err != nil {
+		return nil
+	}
+	t := Table{}
+	td := &decoder{b: data}
+	for len(td.b) > 0 && td.err == nil {
+		name := td.shortstr()
+		t[name] = td.field()
+	}
+	if td.err != nil {
+		d.err = td.err
+		return nil
+	}
+	return t
+}
+
+func (d *decoder) field() any {
+	switch kind := d.octet(); kind {
+	case 't':
+		return d.octet() != 0
+	case 'b':
+		return int8(d.octet())
+	case 'B':
+		return d.octet()
+	case 's':
+		return int16(d.short())
+	case 'u':
+		return d.short()
+	case 'I':
+		return int32(d.long())
+	case 'i':
+		return d.long()
+	case 'l':
+		return int64(d.longlong())
+	case 'f':
+		return math.Float32frombits(d.long())
+	case 'd':
+		return math.Float64frombits(d.longlong())
+	case 'D':
+		return Decimal{Scale: d.octet(), Value: int32(d.long())}
+	case 'S':
+		return string(d.longstr())
+	case 'x':
+		return d.longstr()
+	case 'A':
+		data := d.longstr()
+		ad := &decoder{b: data}
+		values := []any{}
+		for len(ad.b) > 0 && ad.err == nil {
+			values = append(values, ad.field())
+		}
+		if ad.err != nil && d.err == nil {
+			d.err = ad.err
+		}
+		return values
+	case 'T':
+		return time.Unix(int64(d.longlong()), 0)
+	case 'F':
+		return d.table()
+	case 'V':
+		return nil
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown field type %q", kind)
+		}
+		return nil
+	}
+}
+
+// encoder writes method arguments.
+type encoder struct {
+	b      []byte
+	bitPos int
+}
+
+func newMethod(class, method uint16) *encoder {
+	e := &encoder{}
+	e.short(class)
+	e.short(method)
+	return e
+}
+
+func (e *encoder) octet(v byte) {
+	e.bitPos = 0
+	e.b = append(e.b, v)
+}
+
+func (e *encoder) short(v uint16) {
+	e.bitPos = 0
+	e.b = binary.BigEndian.AppendUint16(e.b, v)
+}
+
+func (e *encoder) long(v uint32) {
+	e.bitPos = 0
+	e.b = binary.BigEndian.AppendUint32(e.b, v)
+}
+
+func (e *encoder) longlong(v uint64) {
+	e.bitPos = 0
+	e.b = binary.BigEndian.AppendUint64(e.b, v)
+}
+
+func (e *encoder) shortstr(s string) {
+	if len(s) > math.MaxUint8 {
+		s = s[:math.MaxUint8]
+	}
+	e.octet(byte(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) longstr(v []byte) {
+	e.long(uint32(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *encoder) bit(v bool) {
+	if e.bitPos == 0 || e.bitPos == 8 {
+		e.b = append(e.b, 0)
+		e.bitPos = 0
+	}
+	if v {
+		e.b[len(e.b)-1] |= 1 << e.bitPos
+	}
+	e.bitPos++
+}
+
+// table writes the subset of field types the broker itself sends.
+func (e *encoder) table(t Table) {
+	fields := &encoder{}
+	for name, value := range t {
+		fields.shortstr(name)
+		switch v := value.(type) {
+		case bool:
+			fields.octet('t')
+			if v {
+				fields.octet(1)
+			} else {
+				fields.octet(0)
+			}
+		case string:
+			fields.octet('S')
+			fields.longstr([]byte(v))
+		case int64:
+			fields.octet('l')
+			fields.longlong(uint64(v))
+		case Table:
+			fields.octet('F')
+			fields.table(v)
+		default:
+			fields.octet('V')
+		}
+	}
+	e.longstr(fields.b)
+}
+
+// Basic content properties, in the order of their property flags starting from bit 15.
+const (
+	propContentType = iota
+	propContentEncoding
+	propHeaders
+	propDeliveryMode
+	propPriority
+	propCorrelationID
+	propReplyTo
+	propExpiration
+	propMessageID
+	propTimestamp
+	propType
+	propUserID
+	propAppID
+	propClusterID
+	propCount
+)
+
+// properties are the parts of a message's content properties the broker acts on. The message
+// keeps its raw properties to pass them on to consumers untouched.
+type properties struct {
+	priority   uint8
+	expiration time.Duration
+}
+
+func parseProperties(raw []byte) (properties, error) {
+	d := &decoder{b: raw}
+	flags := d.short()
+	var props properties
+	for i := 0; i < propCount; i++ {
+		if flags&(1<<(15-i)) == 0 {
+			continue
+		}
+		switch i {
+		case propHeaders:
+			d.table()
+		case propDeliveryMode:
+			d.octet()
+		case propPriority:
+			props.priority = d.octet()
+		case propTimestamp:
+			d.longlong()
+		case propExpiration:
+			ms, err := parseExpiration(d.shortstr())
+			if err != nil {
+				return properties{}, err
+			}
+			props.expiration = ms
+		default:
+			d.shortstr()
+		}
+	}
+	if d.err != nil {
+		return properties{}, d.err
+	}
+	return props, nil
+}
+
+func parseExpiration(s string) (time.Duration, error) {
+	var ms int64
+	if s == "" {
+		return 0, errors.New("empty expiration")
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid expiration %q", s)
+		}
+		ms = ms*10 + int64(c-'0')
+	}
+	// A zero expiration expires the message unless it can be delivered straight away.
+	if ms == 0 {
+		return time.Nanosecond, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
@@ -0,0 +1,257 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// checkpointVersion is the version of the checkpoint format. Checkpoints of other versions are not
+// loaded.
+const checkpointVersion = 1
+
+// Checkpoint is every game on the server at one moment, as written by Lobby.Save.
+type Checkpoint struct {
+	Version int
+	SavedAt time.Time
+	Games   []GameCheckpoint
+}
+
+// GameCheckpoint is the state of one game. Queued orders and presence are not kept, and a restored
+// game is played on the server's current map and ruleset.
+type GameCheckpoint struct {
+	ID     string
+	Paused bool
+	Seq    uint64
+	Tick   uint64
+	Turn   int
+	// Elapsed is how long the game had been running, so a time limit carries on where it stopped.
+	Elapsed  time.Duration
+	Members  []string
+	Fielded  []string
+	GameOver *GameOver
+	Players  []PlayerCheckpoint
+}
+
+type PlayerCheckpoint struct {
+	Username   string
+	Units      []Unit
+	Resources  int
+	NextUnitID int
+}
+
+// RestoredGame is a game Lobby.Load put back, with the delta that resets its players.
+type RestoredGame struct {
+	World   *World
+	Created bool
+	Delta   StateDelta
+}
+
+// Save writes every game to path. The file is replaced in one step, like a client save.
+func (l *Lobby) Save(path string) error {
+	now := time.Now()
+	cp := Checkpoint{Version: checkpointVersion, SavedAt: now}
+	for _, w := range l.Games() {
+		cp.Games = append(cp.Games, w.checkpoint(now))
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode checkpoint: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("could not write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("could not write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Load puts back every game saved in path, creating the ones that no longer exist. Games that are
+// not in the checkpoint are left alone. Nothing is restored unless the whole checkpoint is valid.
+func (l *Lobby) Load(path string) ([]RestoredGame, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint: %w", err)
+	}
+	cp := Checkpoint{}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint %s has version %d, expected %d", path, cp.Version, checkpointVersion)
+	}
+	seen := map[string]bool{}
+	for _, game := range cp.Games {
+		if err := l.checkCheckpoint(game); err != nil {
+			return nil, fmt.Errorf("invalid game %s in checkpoint %s: %w", game.ID, path, err)
+		}
+		if seen[game.ID] {
+			return nil, fmt.Errorf("game %s is in checkpoint %s twice", game.ID, path)
+		}
+		seen[game.ID] = true
+	}
+
+	// The missing games are built and added in one step, so nothing can fail once the lobby changes.
+	restored := []RestoredGame{}
+	l.mu.Lock()
+	for _, game := range cp.Games {
+		w, ok := l.games[game.ID]
+		if !ok {
+			w = NewWorld(game.ID, l.gameMap, l.ruleset)
+			w.SetFreezeDisconnected(l.freezeDisconnected)
+			l.games[game.ID] = w
+		}
+		restored = append(restored, RestoredGame{World: w, Created: !ok})
+	}
+	l.mu.Unlock()
+
+	now := time.Now()
+	for i, game := range cp.Games {
+		delta := restored[i].World.restore(game, now)
+		delta.Reason = fmt.Sprintf("a checkpoint saved at %s", cp.SavedAt.Format("2006-01-02 15:04:05"))
+		restored[i].Delta = delta
+	}
+	return restored, nil
+}
+
+// checkCheckpoint makes sure a saved game can be played on the lobby's map and ruleset.
+func (l *Lobby) checkCheckpoint(game GameCheckpoint) error {
+	if err := validGameID(game.ID); err != nil {
+		return err
+	}
+	players := map[string]bool{}
+	for _, player := range game.Players {
+		if player.Username == "" {
+			return errors.New("a player has no username")
+		}
+		if players[player.Username] {
+			return fmt.Errorf("player %s is in the game twice", player.Username)
+		}
+		players[player.Username] = true
+		ids := map[int]bool{}
+		for _, unit := range player.Units {
+			if ids[unit.ID] {
+				return fmt.Errorf("%s has two units with id %d", player.Username, unit.ID)
+			}
+			ids[unit.ID] = true
+			if unit.Owner != player.Username {
+				return fmt.Errorf("unit %d of %s is owned by %q", unit.ID, player.Username, unit.Owner)
+			}
+			if !l.gameMap.HasRegion(unit.Location) {
+				return fmt.Errorf("unit %d of %s is in unknown region %s", unit.ID, player.Username, unit.Location)
+			}
+			if _, ok := l.ruleset.Rank(unit.Rank); !ok {
+				return fmt.Errorf("unit %d of %s has unknown rank %s", unit.ID, player.Username, unit.Rank)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *World) checkpoint(now time.Time) GameCheckpoint {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	game := GameCheckpoint{
+		ID:       w.id,
+		Paused:   w.paused,
+		Seq:      w.seq,
+		Tick:     w.tick,
+		Turn:     w.turn,
+		GameOver: w.gameOver,
+	}
+	if !w.started.IsZero() {
+		game.Elapsed = now.Sub(w.started)
+	}
+	for username := range w.members {
+		game.Members = append(game.Members, username)
+	}
+	sort.Strings(game.Members)
+	for username := range w.fielded {
+		game.Fielded = append(game.Fielded, username)
+	}
+	sort.Strings(game.Fielded)
+	for _, gs := range w.players {
+		gs.mu.RLock()
+		player := PlayerCheckpoint{
+			Username:   gs.Player.Username,
+			Units:      []Unit{},
+			Resources:  gs.Resources,
+			NextUnitID: gs.NextUnitID,
+		}
+		for _, unit := range gs.Player.Units {
+			player.Units = append(player.Units, unit)
+		}
+		gs.mu.RUnlock()
+		sort.Slice(player.Units, func(i, j int) bool { return player.Units[i].ID < player.Units[j].ID })
+		game.Players = append(game.Players, player)
+	}
+	sort.Slice(game.Players, func(i, j int) bool { return game.Players[i].Username < game.Players[j].Username })
+	return game
+}
+
+// restore replaces the world with a checkpoint and returns the delta that resets every player to
+// it. Its number is never lower than the deltas already sent, so clients drop any older delta still
+// on its way. The world stays in the turn mode the server runs in, so a game that had to be created
+// again starts its turns over.
+func (w *World) restore(game GameCheckpoint, now time.Time) StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.players = map[string]*GameState{}
+	for _, player := range game.Players {
+		gs := w.player(player.Username)
+		for _, unit := range player.Units {
+			gs.Player.Units[unit.ID] = unit
+		}
+		gs.Resources = player.Resources
+		gs.NextUnitID = player.NextUnitID
+		w.members[player.Username] = true
+	}
+	// The members are added to, so players who joined since the checkpoint was saved stay in.
+	for _, username := range game.Members {
+		w.members[username] = true
+	}
+	w.fielded = map[string]bool{}
+	for _, username := range game.Fielded {
+		w.fielded[username] = true
+	}
+	w.paused = game.Paused
+	w.seq = max(w.seq, game.Seq)
+	w.tick = game.Tick
+	if w.turnMode {
+		w.turn = max(game.Turn, 1)
+	}
+	w.orders = nil
+	w.started = time.Time{}
+	if len(w.players) > 0 {
+		w.started = now.Add(-game.Elapsed)
+	}
+	w.gameOver = game.GameOver
+	w.ended = time.Time{}
+	if w.gameOver != nil {
+		w.ended = now
+	}
+
+	delta := w.snapshot()
+	delta.Kind = DeltaKindRestored
+	return delta
+}
+
+func (gs *GameState) handleRestore(delta StateDelta) {
+	gs.applySnapshot(delta)
+	gs.mu.Lock()
+	gs.Orders = nil
+	gs.mu.Unlock()
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Game Reset ====")
+	fmt.Printf("The server went back to %s.\n", delta.Reason)
+	fmt.Printf("You have %d unit(s) and %d resources.\n", len(gs.GetPlayerSnap().Units), gs.GetResources())
+}
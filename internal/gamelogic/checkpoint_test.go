@@ -0,0 +1,81 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCheckpoint(t *testing.T, games ...GameCheckpoint) string {
+	t.Helper()
+	data, err := json.Marshal(Checkpoint{Version: checkpointVersion, Games: games})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCreatesMissingGames(t *testing.T) {
+	l := NewLobby(DefaultMap(), DefaultRuleset())
+	units := []Unit{{ID: 1, Rank: RankInfantry, Location: "europe", Owner: "alice"}}
+	path := writeCheckpoint(t, GameCheckpoint{ID: "saved", Players: []PlayerCheckpoint{{Username: "alice", Units: units, NextUnitID: 2}}})
+
+	restored, err := l.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || !restored[0].Created || restored[0].Delta.Kind != DeltaKindRestored {
+		t.Fatalf("got %+v, expected game saved to be created", restored)
+	}
+	w, ok := l.Game("saved")
+	if !ok || w != restored[0].World {
+		t.Fatal("the restored game is not in the lobby")
+	}
+	if info := w.Info(); info.Players != 1 {
+		t.Errorf("got %d players, expected alice", info.Players)
+	}
+}
+
+func TestLoadChangesNothingOnError(t *testing.T) {
+	l := NewLobby(DefaultMap(), DefaultRuleset())
+	path := writeCheckpoint(t, GameCheckpoint{ID: "first"}, GameCheckpoint{ID: "second"}, GameCheckpoint{ID: "first"})
+
+	if _, err := l.Load(path); err == nil {
+		t.Fatal("loaded a checkpoint with the same game twice")
+	}
+	if games := l.Games(); len(games) != 1 {
+		t.Errorf("got %d games, expected only the default game", len(games))
+	}
+}
+
+func TestLoadRejectsDuplicates(t *testing.T) {
+	unit := Unit{ID: 1, Rank: RankInfantry, Location: "europe", Owner: "alice"}
+	tests := []struct {
+		name string
+		game GameCheckpoint
+	}{
+		{"player twice", GameCheckpoint{ID: "saved", Players: []PlayerCheckpoint{
+			{Username: "alice", Units: []Unit{unit}, NextUnitID: 2},
+			{Username: "alice", NextUnitID: 1},
+		}}},
+		{"unit id twice", GameCheckpoint{ID: "saved", Players: []PlayerCheckpoint{
+			{Username: "alice", Units: []Unit{unit, unit}, NextUnitID: 2},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLobby(DefaultMap(), DefaultRuleset())
+			if _, err := l.Load(writeCheckpoint(t, tt.game)); err == nil {
+				t.Fatal("loaded the checkpoint")
+			}
+			if _, ok := l.Game("saved"); ok {
+				t.Error("the game was created anyway")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package gamelogic
+
+import (
+	"fmt"
+)
+
+// HandleDelta applies a StateDelta from the server to the player's view of the world. The server
+// fights the wars moves start and sends their results in deltas of their own. It returns true when
+// deltas were missed, and the player has to ask for a snapshot to catch up.
+func (gs *GameState) HandleDelta(delta StateDelta) (resync bool) {
+	username := gs.GetUsername()
+	switch delta.Kind {
+	case DeltaKindRejected:
+		if delta.Username == username {
+			fmt.Printf("The server rejected your command: %s\n", delta.Reason)
+		}
+		return
+	case DeltaKindJoined, DeltaKindLeft:
+		gs.handlePresence(delta)
+		return
+	case DeltaKindQueued:
+		if delta.Username == username {
+			gs.queueOrder(delta)
+			fmt.Printf("Ordered %v units to %s at the end of the turn\n", len(delta.Units), delta.ToLocation)
+		}
+		return
+	case DeltaKindSnapshot:
+		gs.applySnapshot(delta)
+		if delta.Username == username {
+			fmt.Printf("Synchronized with the server, %d unit(s) are in play and you have %d resources.\n", len(delta.Units), gs.GetResources())
+			gs.reportResume()
+		}
+		return
+	case DeltaKindRestored:
+		gs.handleRestore(delta)
+		return
+	case DeltaKindPlaying:
+		if delta.Playing != nil {
+			gs.HandlePause(*delta.Playing)
+		}
+		return
+	}
+
+	applied, missed := gs.advanceSeq(delta.Seq)
+	if !applied {
+		return
+	}
+	resync = missed && gs.startResync()
+	if delta.Visible != nil {
+		defer gs.setOpponents(delta.Visible)
+	}
+	if delta.Kind == DeltaKindWar {
+		if !gs.checkWarResults(delta.Wars, delta.Units) {
+			fmt.Println("Warning: the server's war result does not match the battles fought locally.")
+		}
+		gs.removeUnits(delta.Units)
+		gs.HandleWarResults(delta.Wars)
+		return
+	}
+	if delta.Kind == DeltaKindGameOver {
+		if delta.GameOver != nil {
+			gs.handleGameOver(delta.GameOver)
+		}
+		return
+	}
+	if delta.Kind == DeltaKindIncome {
+		gs.handleIncome(delta)
+		return
+	}
+	gs.applyUnits(delta.Username, delta.Units)
+
+	switch delta.Kind {
+	case DeltaKindSpawned:
+		if delta.Username == username {
+			if resources, ok := delta.Resources[username]; ok {
+				gs.SetResources(resources)
+			}
+			for _, unit := range delta.Units {
+				fmt.Printf("Spawned a(n) %s in %s with id %v, %d resources left\n", unit.Rank, unit.Location, unit.ID, gs.GetResources())
+			}
+		}
+	case DeltaKindMoved:
+		if delta.Username == username {
+			fmt.Printf("Moved %v units to %s\n", len(delta.Units), delta.ToLocation)
+		}
+		move := ArmyMove{
+			Player:     gs.getPlayer(delta.Username),
+			Units:      delta.Units,
+			ToLocation: delta.ToLocation,
+		}
+		if delta.Turn > 0 {
+			// The server fights the wars of a turn once every move has been made.
+			if delta.Username != username && len(delta.Units) > 0 {
+				fmt.Printf("%s moved %v unit(s) to %s at the end of turn %d\n", delta.Username, len(delta.Units), delta.ToLocation, delta.Turn)
+			}
+			return
+		}
+		gs.HandleMove(move)
+	}
+	return
+}
+
+// advanceSeq records seq as applied. It returns false for a delta that was already applied, and
+// whether deltas before it were missed.
+func (gs *GameState) advanceSeq(seq uint64) (bool, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if seq <= gs.LastSeq {
+		return false, false
+	}
+	missed := seq > gs.LastSeq+1
+	if missed {
+		fmt.Printf("Missed %d update(s) from the server.\n", seq-gs.LastSeq-1)
+	}
+	gs.LastSeq = seq
+	return true, missed
+}
+
+// startResync returns true unless a snapshot was already asked for and has not arrived yet.
+func (gs *GameState) startResync() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.resyncing {
+		return false
+	}
+	gs.resyncing = true
+	return true
+}
+
+func (gs *GameState) applyUnits(username string, units []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if username == gs.Player.Username {
+		for _, unit := range units {
+			gs.Player.Units[unit.ID] = unit
+		}
+		return
+	}
+	opponent, ok := gs.Opponents[username]
+	if !ok {
+		opponent = Player{Username: username, Units: map[int]Unit{}}
+		gs.Opponents[username] = opponent
+	}
+	for _, unit := range units {
+		opponent.Units[unit.ID] = unit
+	}
+}
+
+// setOpponents replaces what the player knows of the other players with the units they can see.
+func (gs *GameState) setOpponents(units []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Opponents = map[string]Player{}
+	for _, unit := range units {
+		opponent, ok := gs.Opponents[unit.Owner]
+		if !ok {
+			opponent = Player{Username: unit.Owner, Units: map[int]Unit{}}
+			gs.Opponents[unit.Owner] = opponent
+		}
+		opponent.Units[unit.ID] = unit
+	}
+}
+
+func (gs *GameState) applySnapshot(delta StateDelta) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Units = map[int]Unit{}
+	gs.Opponents = map[string]Player{}
+	for _, unit := range delta.Units {
+		if unit.Owner == gs.Player.Username {
+			gs.Player.Units[unit.ID] = unit
+			continue
+		}
+		opponent, ok := gs.Opponents[unit.Owner]
+		if !ok {
+			opponent = Player{Username: unit.Owner, Units: map[int]Unit{}}
+			gs.Opponents[unit.Owner] = opponent
+		}
+		opponent.Units[unit.ID] = unit
+	}
+	if delta.Map != nil {
+		gs.Map = delta.Map
+	}
+	if delta.Ruleset != nil {
+		gs.Ruleset = delta.Ruleset
+	}
+	if resources, ok := delta.Resources[gs.Player.Username]; ok {
+		gs.Resources = resources
+	}
+	gs.Turn = delta.Turn
+	gs.GameOver = delta.GameOver
+	gs.Paused = delta.Paused
+	gs.LastSeq = delta.Seq
+	gs.resyncing = false
+}
+
+// getPlayer returns a copy of the player, or of an opponent, as currently known.
+func (gs *GameState) getPlayer(username string) Player {
+	if username == gs.GetUsername() {
+		return gs.GetPlayerSnap()
+	}
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	units := map[int]Unit{}
+	for k, v := range gs.Opponents[username].Units {
+		units[k] = v
+	}
+	return Player{
+		Username: username,
+		Units:    units,
+	}
+}
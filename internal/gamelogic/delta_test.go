@@ -0,0 +1,23 @@
+package gamelogic
+
+import "testing"
+
+func TestMissedDeltasAskForOneSnapshot(t *testing.T) {
+	gs := NewGameState("alice")
+	moved := func(seq uint64) StateDelta {
+		return StateDelta{Seq: seq, Kind: DeltaKindMoved, Username: "bob", Units: []Unit{{ID: 1, Owner: "bob", Rank: RankInfantry, Location: "asia"}}, ToLocation: "asia"}
+	}
+	if gs.HandleDelta(moved(1)) {
+		t.Error("asked for a snapshot without missing anything")
+	}
+	if !gs.HandleDelta(moved(3)) {
+		t.Error("did not ask for a snapshot after missing a delta")
+	}
+	if gs.HandleDelta(moved(5)) {
+		t.Error("asked for a second snapshot before the first arrived")
+	}
+	gs.HandleDelta(StateDelta{Seq: 6, Kind: DeltaKindSnapshot})
+	if !gs.HandleDelta(moved(8)) {
+		t.Error("did not ask for a snapshot after missing a delta once caught up")
+	}
+}
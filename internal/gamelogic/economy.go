@@ -0,0 +1,68 @@
+package gamelogic
+
+import (
+	"fmt"
+)
+
+// computeIncome works out what each player earns on a tick: the ruleset's base income plus the
+// income of every region they control.
+func computeIncome(gameMap *Map, ruleset *Ruleset, players []Player) map[string]int {
+	income := map[string]int{}
+	for _, player := range players {
+		income[player.Username] = ruleset.Economy().BaseIncome
+	}
+	for location, username := range regionControllers(players) {
+		income[username] += gameMap.Income(location)
+	}
+	return income
+}
+
+// regionControllers maps every region where only one player has units to that player.
+func regionControllers(players []Player) map[Location]string {
+	owners := map[Location]map[string]bool{}
+	for _, player := range players {
+		for _, unit := range player.Units {
+			if owners[unit.Location] == nil {
+				owners[unit.Location] = map[string]bool{}
+			}
+			owners[unit.Location][player.Username] = true
+		}
+	}
+	controllers := map[Location]string{}
+	for location, players := range owners {
+		if len(players) != 1 {
+			continue
+		}
+		for username := range players {
+			controllers[location] = username
+		}
+	}
+	return controllers
+}
+
+// expectedIncome is what the player will earn on the next tick, as far as it can tell.
+func (gs *GameState) expectedIncome() int {
+	players := []Player{gs.GetPlayerSnap()}
+	gs.mu.RLock()
+	usernames := make([]string, 0, len(gs.Opponents))
+	for username := range gs.Opponents {
+		usernames = append(usernames, username)
+	}
+	gs.mu.RUnlock()
+	for _, username := range usernames {
+		players = append(players, gs.getPlayer(username))
+	}
+	return computeIncome(gs.GetMap(), gs.GetRuleset(), players)[gs.GetUsername()]
+}
+
+func (gs *GameState) handleIncome(delta StateDelta) {
+	resources, ok := delta.Resources[gs.GetUsername()]
+	if !ok {
+		return
+	}
+	earned := resources - gs.GetResources()
+	gs.SetResources(resources)
+	if earned != 0 {
+		fmt.Printf("Tick %d: you earned %d and now have %d resources.\n", delta.Tick, earned, resources)
+	}
+}
@@ -0,0 +1,49 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeIncome(t *testing.T) {
+	players := []Player{
+		army("alice", Unit{Rank: RankInfantry, Location: "asia"}),
+		army("bob", Unit{Rank: RankInfantry, Location: "asia"}, Unit{Rank: RankInfantry, Location: "europe"}),
+		army("carol"),
+	}
+	// Nobody controls the contested asia, and everyone gets the base income of 1.
+	want := map[string]int{"alice": 1, "bob": 3, "carol": 1}
+	if got := computeIncome(DefaultMap(), DefaultRuleset(), players); !reflect.DeepEqual(got, want) {
+		t.Errorf("got income %v, expected %v", got, want)
+	}
+}
+
+func TestTickPaysIncome(t *testing.T) {
+	w := NewWorld("test", DefaultMap(), DefaultRuleset())
+	if _, ok := w.Tick(); ok {
+		t.Error("ticked before anyone joined")
+	}
+	spawn(t, w, "alice", "asia")
+	spawn(t, w, "bob", "australia")
+
+	for tick, want := range []map[string]int{
+		{"alice": 13, "bob": 11},
+		{"alice": 17, "bob": 13},
+	} {
+		delta, ok := w.Tick()
+		if !ok {
+			t.Fatal("the world did not tick")
+		}
+		if delta.Kind != DeltaKindIncome || delta.Tick != uint64(tick+1) || delta.Seq != uint64(tick+3) {
+			t.Errorf("got %s delta %d for tick %d, expected income delta %d for tick %d", delta.Kind, delta.Seq, delta.Tick, tick+3, tick+1)
+		}
+		if !reflect.DeepEqual(delta.Resources, want) {
+			t.Errorf("tick %d: got resources %v, expected %v", tick+1, delta.Resources, want)
+		}
+	}
+
+	w.SetPaused(true)
+	if _, ok := w.Tick(); ok {
+		t.Error("ticked while paused")
+	}
+}
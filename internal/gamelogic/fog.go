@@ -0,0 +1,131 @@
+package gamelogic
+
+import "sort"
+
+// Delivery is a delta as one player may see it.
+type Delivery struct {
+	Username string
+	Delta    StateDelta
+}
+
+// Broadcast is a delta with what each member saw of it. The deliveries are made as soon as the
+// delta is, so the wars that follow a move can not hide the move from the players it was shown to.
+type Broadcast struct {
+	Delta      StateDelta
+	Deliveries []Delivery
+}
+
+// Deliver returns the version of delta each member of the game may see. Players see other players'
+// units only in the regions they have units in and the regions next to those, and only their own
+// resources. Rejections, queued orders and the snapshot answering a sync only go to the player who
+// asked.
+func (w *World) Deliver(delta StateDelta) []Delivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.deliver(delta)
+}
+
+func (w *World) broadcast(delta StateDelta) Broadcast {
+	return Broadcast{Delta: delta, Deliveries: w.deliver(delta)}
+}
+
+func (w *World) deliver(delta StateDelta) []Delivery {
+	switch {
+	case delta.Kind == DeltaKindRejected, delta.Kind == DeltaKindQueued:
+		return []Delivery{{Username: delta.Username, Delta: delta}}
+	case delta.Kind == DeltaKindSnapshot && delta.Username != "":
+		return []Delivery{{Username: delta.Username, Delta: w.view(delta.Username, delta)}}
+	}
+	usernames := make([]string, 0, len(w.members))
+	for username := range w.members {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	deliveries := make([]Delivery, 0, len(usernames))
+	for _, username := range usernames {
+		deliveries = append(deliveries, Delivery{Username: username, Delta: w.view(username, delta)})
+	}
+	return deliveries
+}
+
+// visibleRegions are the regions viewer has units in and the regions next to them.
+func (w *World) visibleRegions(viewer string) map[Location]bool {
+	visible := map[Location]bool{}
+	gs, ok := w.players[viewer]
+	if !ok {
+		return visible
+	}
+	for _, unit := range gs.GetPlayerSnap().Units {
+		visible[unit.Location] = true
+		for _, neighbour := range w.gameMap.Neighbours(unit.Location) {
+			visible[neighbour] = true
+		}
+	}
+	return visible
+}
+
+// view is delta as viewer may see it.
+func (w *World) view(viewer string, delta StateDelta) StateDelta {
+	visible := w.visibleRegions(viewer)
+	if delta.Kind == DeltaKindWar {
+		// Both sides of a battle see it, even when it cost them every unit they had there.
+		wars := []WarResult{}
+		for _, result := range delta.Wars {
+			if result.Attacker == viewer || result.Defender == viewer || visible[result.Location] {
+				visible[result.Location] = true
+				wars = append(wars, result)
+			}
+		}
+		delta.Wars = wars
+	}
+	sees := func(unit Unit) bool {
+		return unit.Owner == viewer || visible[unit.Location]
+	}
+
+	if delta.Units != nil {
+		units := []Unit{}
+		for _, unit := range delta.Units {
+			if sees(unit) {
+				units = append(units, unit)
+			}
+		}
+		delta.Units = units
+	}
+	if delta.Kind == DeltaKindMoved && delta.Username != viewer && !visible[delta.ToLocation] {
+		delta.ToLocation = ""
+	}
+	if delta.Resources != nil {
+		resources := map[string]int{}
+		if amount, ok := delta.Resources[viewer]; ok {
+			resources[viewer] = amount
+		}
+		delta.Resources = resources
+	}
+	// Snapshots already hold everything the viewer can see, other numbered deltas bring the viewer's
+	// picture of the other players up to date.
+	if delta.Seq > 0 && delta.Kind != DeltaKindSnapshot && delta.Kind != DeltaKindRestored {
+		delta.Visible = []Unit{}
+		for username, gs := range w.players {
+			if username == viewer {
+				continue
+			}
+			for _, unit := range gs.GetPlayerSnap().Units {
+				if visible[unit.Location] {
+					delta.Visible = append(delta.Visible, unit)
+				}
+			}
+		}
+		sortUnits(delta.Visible)
+	}
+	return delta
+}
+
+// sortUnits orders units by owner and ID.
+func sortUnits(units []Unit) {
+	sort.Slice(units, func(i, j int) bool {
+		if units[i].Owner != units[j].Owner {
+			return units[i].Owner < units[j].Owner
+		}
+		return units[i].ID < units[j].ID
+	})
+}
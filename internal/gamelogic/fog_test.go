@@ -0,0 +1,64 @@
+package gamelogic
+
+import "testing"
+
+func spawn(t *testing.T, w *World, username string, location Location) StateDelta {
+	t.Helper()
+	w.Join(username)
+	delta := w.HandleCommand(CommandRequest{Username: username, Command: CommandKindSpawn, Location: location, Rank: "infantry"})[0].Delta
+	if delta.Kind != DeltaKindSpawned {
+		t.Fatalf("%s could not spawn in %s: %s", username, location, delta.Reason)
+	}
+	return delta
+}
+
+func deliveryTo(t *testing.T, deliveries []Delivery, username string) StateDelta {
+	t.Helper()
+	for _, d := range deliveries {
+		if d.Username == username {
+			return d.Delta
+		}
+	}
+	t.Fatalf("nothing was delivered to %s", username)
+	return StateDelta{}
+}
+
+func TestDeliverHidesDistantUnits(t *testing.T) {
+	w := NewWorld("test", DefaultMap(), DefaultRuleset())
+	spawn(t, w, "alice", "americas")
+	far := spawn(t, w, "bob", "australia")
+
+	deliveries := w.Deliver(far)
+	if len(deliveries) != 2 {
+		t.Fatalf("got %d deliveries, expected one for each player", len(deliveries))
+	}
+	alice := deliveryTo(t, deliveries, "alice")
+	if len(alice.Units) != 0 || len(alice.Visible) != 0 {
+		t.Errorf("alice sees bob's units in australia: %v %v", alice.Units, alice.Visible)
+	}
+	if _, ok := alice.Resources["bob"]; ok {
+		t.Errorf("alice sees bob's resources: %v", alice.Resources)
+	}
+	bob := deliveryTo(t, deliveries, "bob")
+	if len(bob.Units) != 1 || bob.Resources["bob"] == 0 {
+		t.Errorf("bob does not see his own spawn: %+v", bob)
+	}
+
+	near := spawn(t, w, "carol", "asia")
+	alice = deliveryTo(t, w.Deliver(near), "alice")
+	if len(alice.Units) != 1 || len(alice.Visible) != 1 || alice.Visible[0].Owner != "carol" {
+		t.Errorf("alice does not see carol's unit next door: %v %v", alice.Units, alice.Visible)
+	}
+}
+
+func TestDeliverRejectionOnlyToRequester(t *testing.T) {
+	w := NewWorld("test", DefaultMap(), DefaultRuleset())
+	spawn(t, w, "alice", "americas")
+	spawn(t, w, "bob", "europe")
+
+	rejected := w.HandleCommand(CommandRequest{Username: "bob", Command: CommandKindSpawn, Location: "nowhere", Rank: "infantry"})[0].Delta
+	deliveries := w.Deliver(rejected)
+	if len(deliveries) != 1 || deliveries[0].Username != "bob" {
+		t.Errorf("rejection was delivered to %v", deliveries)
+	}
+}
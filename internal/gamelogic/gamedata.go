@@ -1,5 +1,11 @@
 package gamelogic
 
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
 type Player struct {
 	Username string
 	Units    map[int]Unit
@@ -15,31 +21,137 @@ const (
 
 type Unit struct {
 	ID       int
+	Owner    string
 	Rank     UnitRank
 	Location Location
 }
 
+// UnitKey identifies a unit across all players. IDs are only unique per player, so the owner is
+// part of the key.
+type UnitKey struct {
+	Owner string
+	ID    int
+}
+
+func (k UnitKey) String() string {
+	return fmt.Sprintf("%s#%d", k.Owner, k.ID)
+}
+
+func (u Unit) Key() UnitKey {
+	return UnitKey{Owner: u.Owner, ID: u.ID}
+}
+
 type ArmyMove struct {
 	Player     Player
 	Units      []Unit
 	ToLocation Location
 }
 
-type RecognitionOfWar struct {
-	Attacker Player
-	Defender Player
+// Commands a player can ask the server to carry out.
+const (
+	CommandKindSpawn = "spawn"
+	CommandKindMove  = "move"
+	CommandKindSync  = "sync"
+)
+
+// CommandRequest asks the server to change the player's units. Nothing changes until the server
+// accepts it and broadcasts a StateDelta.
+type CommandRequest struct {
+	GameID     string
+	Username   string
+	Command    string
+	Location   Location
+	Rank       UnitRank
+	UnitIDs    []int
+	Path       []Location
+	ToLocation Location
+}
+
+// Kinds of StateDelta broadcast by the server.
+const (
+	DeltaKindSpawned  = "spawned"
+	DeltaKindMoved    = "moved"
+	DeltaKindWar      = "war"
+	DeltaKindSnapshot = "snapshot"
+	DeltaKindRejected = "rejected"
+	DeltaKindIncome   = "income"
+	DeltaKindQueued   = "queued"
+	DeltaKindGameOver = "game_over"
+	DeltaKindJoined   = "joined"
+	DeltaKindLeft     = "left"
+	DeltaKindRestored = "restored"
+	DeltaKindPlaying  = "playing"
+)
+
+// StateDelta is a change to the world the server accepted, or the rejection of a CommandRequest.
+type StateDelta struct {
+	// Seq numbers accepted deltas so clients can drop duplicates. Queued, joined and left deltas
+	// are unnumbered, and a snapshot carries the number of the last delta it includes.
+	Seq  uint64
+	Kind string
+	// Username is the player whose command, or arrival or departure, the delta is about.
+	Username string
+	// Units are the units that changed: every unit in the world for a snapshot, the units killed
+	// for a war, and where the units will be once the turn ends for a queued move.
+	Units      []Unit
+	ToLocation Location
+	// Reason explains a rejection, or says what a restored delta reset the world to: a checkpoint or
+	// a new game.
+	Reason string
+	// Wars is the result of the battle in each contested location.
+	Wars []WarResult
+	// Map and Ruleset come with snapshots.
+	Map     *Map
+	Ruleset *Ruleset
+	// Resources is the new balance of the players whose resources changed. Income deltas carry
+	// everyone's balance once per Tick.
+	Resources map[string]int
+	Tick      uint64
+	// Turn is set on the moves made when a turn ends.
+	Turn int
+	// GameOver is the final standings, on the game over delta and any snapshot taken after it.
+	GameOver *GameOver
+	// Paused is whether the game is paused, on snapshots.
+	Paused bool
+	// Playing is the pause, resume or turn change a playing delta announces. Playing deltas are sent
+	// at high priority, so they overtake the moves still waiting for the player.
+	Playing *routing.PlayingState
+	// Visible is every unit of the other players the receiver can see after the change.
+	Visible []Unit
 }
 
-type Location string
+// WarResult is the server's ruling on a war. Winner and Loser are empty for a draw. Seed is what
+// the dice were rolled with, so anyone can fight the same battle again and check the result.
+type WarResult struct {
+	Attacker      string
+	Defender      string
+	Location      Location
+	Combat        string
+	Seed          int64
+	Rounds        int
+	AttackerUnits []Unit
+	DefenderUnits []Unit
+	AttackerPower int
+	DefenderPower int
+	Winner        string
+	Loser         string
+	Draw          bool
+	Report        BattleReport
+}
 
-func getAllRanks() map[UnitRank]struct{} {
-	return map[UnitRank]struct{}{
-		RankInfantry:  {},
-		RankCavalry:   {},
-		RankArtillery: {},
-	}
+// BattleReport is what a battle cost each side.
+type BattleReport struct {
+	Location          Location
+	Attacker          string
+	Defender          string
+	AttackerLosses    []Unit
+	DefenderLosses    []Unit
+	AttackerSurvivors []Unit
+	DefenderSurvivors []Unit
 }
 
+type Location string
+
 func getAllLocations() map[Location]struct{} {
 	return map[Location]struct{}{
 		"americas":   {},
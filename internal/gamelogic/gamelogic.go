@@ -11,13 +11,21 @@ import (
 
 func PrintClientHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* move <location> <unitID> <unitID> <unitID>...")
-	fmt.Println("    example:")
+	fmt.Println("* move <location>[,<location>...] <unitID> <unitID> <unitID>...")
+	fmt.Println("    examples:")
 	fmt.Println("    move asia 1")
+	fmt.Println("    move africa,asia 1 2")
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
 	fmt.Println("* status")
+	fmt.Println("* map")
+	fmt.Println("* ranks")
+	fmt.Println("* games")
+	fmt.Println("* create <game>")
+	fmt.Println("* join [game]")
+	fmt.Println("    example:")
+	fmt.Println("    join main")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -25,23 +33,34 @@ func PrintClientHelp() {
 	fmt.Println("* help")
 }
 
-func ClientWelcome() (string, error) {
+// ClientWelcome asks for the username and offers to resume the player's last session, if it was
+// saved. Otherwise it returns a new state.
+func ClientWelcome() (*GameState, error) {
 	fmt.Println("Welcome to the Peril client!")
 	fmt.Println("Please enter your username:")
 	words := GetInput()
 	if len(words) == 0 {
-		return "", errors.New("you must enter a username. goodbye")
+		return nil, errors.New("you must enter a username. goodbye")
 	}
 	username := words[0]
 	fmt.Printf("Welcome, %s!\n", username)
+	gs := offerResume(username)
+	if gs == nil {
+		gs = NewGameState(username)
+	}
 	PrintClientHelp()
-	return username, nil
+	return gs, nil
 }
 
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* pause")
-	fmt.Println("* resume")
+	fmt.Println("* games")
+	fmt.Println("* players [game]")
+	fmt.Println("* pause [game]")
+	fmt.Println("* resume [game]")
+	fmt.Println("* save <file>")
+	fmt.Println("* load <file>")
+	fmt.Println("* throttled")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -77,7 +96,10 @@ func PrintQuit() {
 }
 
 func (gs *GameState) CommandStatus() {
-	if gs.isPaused() {
+	if over := gs.GetGameOver(); over != nil {
+		fmt.Println("The game is over.", over.Summary())
+	}
+	if gs.IsPaused() {
 		fmt.Println("The game is paused.")
 		return
 	} else {
@@ -86,7 +108,41 @@ func (gs *GameState) CommandStatus() {
 
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	fmt.Printf("You have %d resources and earn about %d per tick.\n", gs.GetResources(), gs.expectedIncome())
+	if turn, orders := gs.getOrders(); turn > 0 {
+		fmt.Printf("It is turn %d, and you have %d order(s) queued.\n", turn, len(orders))
+		for _, order := range orders {
+			fmt.Printf("* move %v to %s\n", order.UnitIDs, order.ToLocation)
+		}
+	}
 	for _, unit := range p.Units {
 		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
 	}
 }
+
+func (gs *GameState) CommandMap() {
+	gameMap := gs.GetMap()
+	fmt.Printf("A move can cost up to %d.\n", gameMap.MovePoints())
+	for _, region := range gameMap.Regions() {
+		fmt.Printf("* %s (income %d):", region, gameMap.Income(region))
+		for _, neighbour := range gameMap.Neighbours(region) {
+			cost, _ := gameMap.PathCost(region, []Location{neighbour})
+			fmt.Printf(" %s (%d)", neighbour, cost)
+		}
+		fmt.Println()
+	}
+}
+
+func (gs *GameState) CommandRanks() {
+	for _, rank := range gs.GetRuleset().Ranks() {
+		speed := "map default"
+		if rank.Speed > 0 {
+			speed = fmt.Sprint(rank.Speed)
+		}
+		fmt.Printf("* %s: power %d, cost %d, speed %s", rank.Name, rank.Power, rank.Cost, speed)
+		if len(rank.Abilities) > 0 {
+			fmt.Printf(", abilities: %s", strings.Join(rank.Abilities, ", "))
+		}
+		fmt.Println()
+	}
+}
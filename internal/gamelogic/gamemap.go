@@ -0,0 +1,225 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// MapEnv names a JSON map file for the server to play on instead of the default map.
+const MapEnv = "PERIL_MAP"
+
+const defaultRegionIncome = 1
+
+// Map is the board: the regions units can be in and the edges they move along. Every edge can be
+// travelled both ways. A single move can follow a path of edges whose costs add up to MovePoints.
+// Each region pays its income to the player controlling it on every tick.
+type Map struct {
+	regions    []Location
+	edges      []Edge
+	movePoints int
+	income     map[Location]int
+	adjacent   map[Location]map[Location]int
+}
+
+type Edge struct {
+	From Location `json:"from"`
+	To   Location `json:"to"`
+	Cost int      `json:"cost"`
+}
+
+// mapFile is how a Map is written in a map file, and on the wire.
+type mapFile struct {
+	Regions    []Location       `json:"regions"`
+	Edges      []Edge           `json:"edges"`
+	MovePoints int              `json:"move_points"`
+	Income     map[Location]int `json:"income,omitempty"`
+}
+
+// NewMap creates a map. Regions missing from income pay defaultRegionIncome.
+func NewMap(regions []Location, edges []Edge, movePoints int, income map[Location]int) (*Map, error) {
+	if len(regions) == 0 {
+		return nil, errors.New("map has no regions")
+	}
+	if movePoints <= 0 {
+		return nil, errors.New("move points must be positive")
+	}
+	m := &Map{
+		regions:    append([]Location{}, regions...),
+		edges:      append([]Edge{}, edges...),
+		movePoints: movePoints,
+		income:     map[Location]int{},
+		adjacent:   map[Location]map[Location]int{},
+	}
+	for _, region := range regions {
+		if region == "" {
+			return nil, errors.New("region names can not be empty")
+		}
+		if _, ok := m.adjacent[region]; ok {
+			return nil, fmt.Errorf("region %s is listed twice", region)
+		}
+		m.adjacent[region] = map[Location]int{}
+		m.income[region] = defaultRegionIncome
+	}
+	for region, amount := range income {
+		if _, ok := m.adjacent[region]; !ok {
+			return nil, fmt.Errorf("income for unknown region %s", region)
+		}
+		if amount < 0 {
+			return nil, fmt.Errorf("region %s can not have negative income", region)
+		}
+		m.income[region] = amount
+	}
+	for _, edge := range edges {
+		if _, ok := m.adjacent[edge.From]; !ok {
+			return nil, fmt.Errorf("edge from unknown region %s", edge.From)
+		}
+		if _, ok := m.adjacent[edge.To]; !ok {
+			return nil, fmt.Errorf("edge to unknown region %s", edge.To)
+		}
+		if edge.From == edge.To {
+			return nil, fmt.Errorf("edge from %s to itself", edge.From)
+		}
+		if edge.Cost <= 0 {
+			return nil, fmt.Errorf("edge from %s to %s must have a positive cost", edge.From, edge.To)
+		}
+		if _, ok := m.adjacent[edge.From][edge.To]; ok {
+			return nil, fmt.Errorf("edge between %s and %s is listed twice", edge.From, edge.To)
+		}
+		m.adjacent[edge.From][edge.To] = edge.Cost
+		m.adjacent[edge.To][edge.From] = edge.Cost
+	}
+	return m, nil
+}
+
+// DefaultMap is the six continents, joined the way they are on a globe.
+func DefaultMap() *Map {
+	regions := []Location{}
+	for location := range getAllLocations() {
+		regions = append(regions, location)
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i] < regions[j] })
+	m, err := NewMap(regions, []Edge{
+		{From: "americas", To: "europe", Cost: 2},
+		{From: "americas", To: "asia", Cost: 2},
+		{From: "americas", To: "antarctica", Cost: 2},
+		{From: "europe", To: "africa", Cost: 1},
+		{From: "europe", To: "asia", Cost: 1},
+		{From: "africa", To: "asia", Cost: 1},
+		{From: "africa", To: "antarctica", Cost: 2},
+		{From: "asia", To: "australia", Cost: 1},
+		{From: "australia", To: "antarctica", Cost: 2},
+	}, 2, map[Location]int{
+		"americas":   3,
+		"europe":     2,
+		"africa":     2,
+		"asia":       3,
+		"australia":  1,
+		"antarctica": 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func LoadMap(path string) (*Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read map: %w", err)
+	}
+	var m Map
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid map %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// LoadMapFromEnv loads the map named by env, or returns the default map if env is not set.
+func LoadMapFromEnv(env string) (*Map, error) {
+	path := os.Getenv(env)
+	if path == "" {
+		return DefaultMap(), nil
+	}
+	return LoadMap(path)
+}
+
+func (m *Map) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mapFile{Regions: m.regions, Edges: m.edges, MovePoints: m.movePoints, Income: m.income})
+}
+
+func (m *Map) UnmarshalJSON(data []byte) error {
+	var f mapFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	loaded, err := NewMap(f.Regions, f.Edges, f.MovePoints, f.Income)
+	if err != nil {
+		return err
+	}
+	*m = *loaded
+	return nil
+}
+
+func (m *Map) Regions() []Location {
+	return append([]Location{}, m.regions...)
+}
+
+func (m *Map) MovePoints() int {
+	return m.movePoints
+}
+
+func (m *Map) Income(location Location) int {
+	return m.income[location]
+}
+
+func (m *Map) HasRegion(location Location) bool {
+	_, ok := m.adjacent[location]
+	return ok
+}
+
+// Neighbours returns the regions one edge away from location, in name order.
+func (m *Map) Neighbours(location Location) []Location {
+	neighbours := []Location{}
+	for neighbour := range m.adjacent[location] {
+		neighbours = append(neighbours, neighbour)
+	}
+	sort.Slice(neighbours, func(i, j int) bool { return neighbours[i] < neighbours[j] })
+	return neighbours
+}
+
+// PathCost returns what it costs to move from start through each region of path in turn. Every
+// step has to follow an edge.
+func (m *Map) PathCost(start Location, path []Location) (int, error) {
+	if len(path) == 0 {
+		return 0, errors.New("the path is empty")
+	}
+	cost := 0
+	from := start
+	for _, to := range path {
+		if !m.HasRegion(to) {
+			return 0, fmt.Errorf("%s is not a valid location", to)
+		}
+		step, ok := m.adjacent[from][to]
+		if !ok {
+			return 0, fmt.Errorf("%s is not adjacent to %s", to, from)
+		}
+		cost += step
+		from = to
+	}
+	return cost, nil
+}
+
+// checkMove reports whether a unit in start can follow path in a single move costing up to budget.
+func (m *Map) checkMove(start Location, path []Location, budget int) error {
+	cost, err := m.PathCost(start, path)
+	if err != nil {
+		return err
+	}
+	if cost > budget {
+		return fmt.Errorf("moving from %s to %s costs %d, but the unit can only move %d", start, path[len(path)-1], cost, budget)
+	}
+	return nil
+}
@@ -5,9 +5,31 @@ import (
 )
 
 type GameState struct {
+	// GameID is the game the player has joined, or empty while they are in the lobby.
+	GameID string
 	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	// Opponents are the other players as last reported by the server.
+	Opponents map[string]Player
+	Map       *Map
+	Ruleset   *Ruleset
+	Paused    bool
+	// Resources is what the player has left to spawn units with.
+	Resources int
+	// Turn is the current turn in turn mode, and Orders are the moves queued for the end of it.
+	Turn   int
+	Orders []CommandRequest
+	// GameOver is set once the server has declared a winner, and then no more commands are taken.
+	GameOver *GameOver
+	// NextUnitID is the ID the next spawned unit gets. It only ever grows, so IDs of dead units are
+	// never reused.
+	NextUnitID int
+	// LastSeq is the number of the last StateDelta applied.
+	LastSeq uint64
+	// resyncing is set while a snapshot asked for after missed deltas is on its way.
+	resyncing bool
+	// resumed is what a resumed save said about the player, until the server's snapshot arrives.
+	resumed *resumePoint
+	mu      *sync.RWMutex
 }
 
 func NewGameState(username string) *GameState {
@@ -16,8 +38,12 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Opponents:  map[string]Player{},
+		Map:        DefaultMap(),
+		Ruleset:    DefaultRuleset(),
+		Paused:     false,
+		NextUnitID: 1,
+		mu:         &sync.RWMutex{},
 	}
 }
 
@@ -33,24 +59,44 @@ func (gs *GameState) pauseGame() {
 	gs.Paused = true
 }
 
-func (gs *GameState) isPaused() bool {
+func (gs *GameState) IsPaused() bool {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
 	return gs.Paused
 }
 
+// allocateUnitID returns a new unit ID for the player. It also skips past any ID already in use, in
+// case the units came from a state saved before IDs were allocated.
+func (gs *GameState) allocateUnitID() int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for id := range gs.Player.Units {
+		if id >= gs.NextUnitID {
+			gs.NextUnitID = id + 1
+		}
+	}
+	id := gs.NextUnitID
+	gs.NextUnitID++
+	return id
+}
+
 func (gs *GameState) addUnit(u Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	gs.Player.Units[u.ID] = u
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+// removeUnits deletes the units, whether they belong to the player or an opponent.
+func (gs *GameState) removeUnits(units []Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
+	for _, unit := range units {
+		if unit.Owner == gs.Player.Username {
+			delete(gs.Player.Units, unit.ID)
+			continue
+		}
+		if opponent, ok := gs.Opponents[unit.Owner]; ok {
+			delete(opponent.Units, unit.ID)
 		}
 	}
 }
@@ -61,6 +107,30 @@ func (gs *GameState) UpdateUnit(u Unit) {
 	gs.Player.Units[u.ID] = u
 }
 
+func (gs *GameState) GetResources() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Resources
+}
+
+func (gs *GameState) SetResources(resources int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Resources = resources
+}
+
+func (gs *GameState) GetMap() *Map {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Map
+}
+
+func (gs *GameState) GetRuleset() *Ruleset {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Ruleset
+}
+
 func (gs *GameState) GetUsername() string {
 	return gs.Player.Username
 }
@@ -0,0 +1,235 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// Commands a player can send to the lobby.
+const (
+	LobbyCommandList   = "list"
+	LobbyCommandCreate = "create"
+	LobbyCommandJoin   = "join"
+)
+
+const maxGameIDLength = 32
+
+// LobbyRequest asks the server to list, create or join a game.
+type LobbyRequest struct {
+	Username string
+	Command  string
+	GameID   string
+}
+
+// LobbyReply is the server's answer to a LobbyRequest. Error is set if the request failed.
+type LobbyReply struct {
+	Username string
+	Command  string
+	GameID   string
+	Games    []GameInfo
+	Error    string
+}
+
+// GameInfo describes a game in the lobby.
+type GameInfo struct {
+	ID      string
+	Players int
+	Paused  bool
+	Turn    int
+	Over    bool
+}
+
+// Lobby holds every game the server is running. It always has the routing.DefaultGameID game.
+type Lobby struct {
+	gameMap *Map
+	ruleset *Ruleset
+	games   map[string]*World
+	// freezeDisconnected is passed on to every game.
+	freezeDisconnected bool
+	mu                 *sync.Mutex
+}
+
+func NewLobby(gameMap *Map, ruleset *Ruleset) *Lobby {
+	l := &Lobby{
+		gameMap: gameMap,
+		ruleset: ruleset,
+		games:   map[string]*World{},
+		mu:      &sync.Mutex{},
+	}
+	l.games[routing.DefaultGameID] = NewWorld(routing.DefaultGameID, gameMap, ruleset)
+	return l
+}
+
+// validGameID makes sure a game ID is one word of a routing key.
+func validGameID(id string) error {
+	if id == "" {
+		return errors.New("the game needs a name")
+	}
+	if len(id) > maxGameIDLength {
+		return fmt.Errorf("game names can be at most %d characters", maxGameIDLength)
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("game names can only have lowercase letters, digits, - and _, not %q", r)
+		}
+	}
+	return nil
+}
+
+func (l *Lobby) Create(id string) (*World, error) {
+	if err := validGameID(id); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.games[id]; ok {
+		return nil, fmt.Errorf("game %s already exists", id)
+	}
+	w := NewWorld(id, l.gameMap, l.ruleset)
+	w.SetFreezeDisconnected(l.freezeDisconnected)
+	l.games[id] = w
+	return w, nil
+}
+
+// SetFreezeDisconnected freezes the units of disconnected players in every game, including the
+// games created later.
+func (l *Lobby) SetFreezeDisconnected(freeze bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.freezeDisconnected = freeze
+	for _, w := range l.games {
+		w.SetFreezeDisconnected(freeze)
+	}
+}
+
+func (l *Lobby) Game(id string) (*World, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.games[id]
+	return w, ok
+}
+
+// Games returns every game, in name order.
+func (l *Lobby) Games() []*World {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	games := make([]*World, 0, len(l.games))
+	for _, w := range l.games {
+		games = append(games, w)
+	}
+	sort.Slice(games, func(i, j int) bool { return games[i].ID() < games[j].ID() })
+	return games
+}
+
+// HandleRequest answers a LobbyRequest. It also returns the game the request created, if any.
+func (l *Lobby) HandleRequest(req LobbyRequest) (LobbyReply, *World) {
+	reply := LobbyReply{Username: req.Username, Command: req.Command, GameID: req.GameID}
+	var created *World
+	var err error
+	switch req.Command {
+	case LobbyCommandList:
+	case LobbyCommandCreate:
+		created, err = l.Create(req.GameID)
+	case LobbyCommandJoin:
+		if w, ok := l.Game(req.GameID); ok {
+			w.Join(req.Username)
+		} else {
+			err = fmt.Errorf("there is no game called %s", req.GameID)
+		}
+	default:
+		err = fmt.Errorf("unknown lobby command %q", req.Command)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+		return reply, nil
+	}
+	for _, w := range l.Games() {
+		reply.Games = append(reply.Games, w.Info())
+	}
+	return reply, created
+}
+
+// CommandLobby turns the games, create and join commands into a LobbyRequest. A client can only
+// join one game.
+func (gs *GameState) CommandLobby(words []string) (LobbyRequest, error) {
+	req := LobbyRequest{Username: gs.GetUsername()}
+	switch words[0] {
+	case "games":
+		req.Command = LobbyCommandList
+	case "create":
+		if len(words) < 2 {
+			return LobbyRequest{}, errors.New("usage: create <game>")
+		}
+		req.Command = LobbyCommandCreate
+		req.GameID = words[1]
+	case "join":
+		if gameID := gs.GetGameID(); gameID != "" {
+			return LobbyRequest{}, fmt.Errorf("you are already playing in %s", gameID)
+		}
+		req.Command = LobbyCommandJoin
+		req.GameID = routing.DefaultGameID
+		if len(words) > 1 {
+			req.GameID = words[1]
+		}
+	default:
+		return LobbyRequest{}, fmt.Errorf("unknown lobby command %s", words[0])
+	}
+	if req.GameID != "" {
+		if err := validGameID(req.GameID); err != nil {
+			return LobbyRequest{}, err
+		}
+	}
+	return req, nil
+}
+
+// HandleLobbyReply prints the server's answer. It returns true if the player may now join the game.
+func (gs *GameState) HandleLobbyReply(reply LobbyReply) bool {
+	if reply.Error != "" {
+		fmt.Println("The lobby turned you down:", reply.Error)
+		return false
+	}
+	switch reply.Command {
+	case LobbyCommandCreate:
+		fmt.Printf("Created game %s, use \"join %s\" to play in it.\n", reply.GameID, reply.GameID)
+	case LobbyCommandJoin:
+		if gameID := gs.GetGameID(); gameID != "" {
+			fmt.Printf("You are already playing in %s.\n", gameID)
+			return false
+		}
+		fmt.Printf("Joining game %s...\n", reply.GameID)
+		return true
+	default:
+		fmt.Printf("There are %d game(s):\n", len(reply.Games))
+		for _, game := range reply.Games {
+			state := "playing"
+			switch {
+			case game.Over:
+				state = "over"
+			case game.Paused:
+				state = "paused"
+			}
+			fmt.Printf("* %s: %d player(s), %s", game.ID, game.Players, state)
+			if game.Turn > 0 {
+				fmt.Printf(", turn %d", game.Turn)
+			}
+			fmt.Println()
+		}
+	}
+	return false
+}
+
+func (gs *GameState) GetGameID() string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.GameID
+}
+
+func (gs *GameState) SetGameID(gameID string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.GameID = gameID
+}
@@ -3,7 +3,9 @@ package gamelogic
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 type MoveOutcome int
@@ -29,64 +31,132 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 		return MoveOutcomeSamePlayer
 	}
 
-	overlappingLocation := getOverlappingLocation(player, move.Player)
-	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
+	overlappingLocations := getOverlappingLocations(player, move.Player)
+	if len(overlappingLocations) > 0 {
+		fmt.Printf("You have units in %s! You are at war with %s!\n", joinLocations(overlappingLocations), move.Player.Username)
 		return MoveOutcomeMakeWar
 	}
 	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
 	return MoveOutComeSafe
 }
 
-func getOverlappingLocation(p1 Player, p2 Player) Location {
+// getOverlappingLocations returns every location where both players have units, in name order.
+func getOverlappingLocations(p1 Player, p2 Player) []Location {
+	occupied := map[Location]bool{}
 	for _, u1 := range p1.Units {
-		for _, u2 := range p2.Units {
-			if u1.Location == u2.Location {
-				return u1.Location
-			}
+		occupied[u1.Location] = true
+	}
+	overlapping := map[Location]bool{}
+	for _, u2 := range p2.Units {
+		if occupied[u2.Location] {
+			overlapping[u2.Location] = true
 		}
 	}
-	return ""
+	locations := []Location{}
+	for location := range overlapping {
+		locations = append(locations, location)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i] < locations[j] })
+	return locations
 }
 
-func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
-	if gs.isPaused() {
-		return ArmyMove{}, errors.New("the game is paused, you can not move units")
+func joinLocations(locations []Location) string {
+	names := []string{}
+	for _, location := range locations {
+		names = append(names, string(location))
+	}
+	return strings.Join(names, ", ")
+}
+
+func (gs *GameState) CommandMove(words []string) (CommandRequest, error) {
+	if gs.GetGameID() == "" {
+		return CommandRequest{}, errors.New("join a game before moving units")
+	}
+	if gs.IsGameOver() {
+		return CommandRequest{}, errors.New("the game is over, you can not move units")
+	}
+	if gs.IsPaused() {
+		return CommandRequest{}, errors.New("the game is paused, you can not move units")
 	}
 	if len(words) < 3 {
-		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
+		return CommandRequest{}, errors.New("usage: move <location>[,<location>...] <unitID> <unitID> <unitID> etc")
 	}
-	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
-		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
+	path := []Location{}
+	for _, name := range strings.Split(words[1], ",") {
+		path = append(path, Location(name))
 	}
+	gameMap := gs.GetMap()
+	ruleset := gs.GetRuleset()
 	unitIDs := []int{}
 	for _, word := range words[2:] {
 		id := word
 		unitID, err := strconv.Atoi(id)
 		if err != nil {
-			return ArmyMove{}, fmt.Errorf("error: %s is not a valid unit ID", id)
+			return CommandRequest{}, fmt.Errorf("error: %s is not a valid unit ID", id)
+		}
+		unit, ok := gs.GetUnit(unitID)
+		if !ok {
+			return CommandRequest{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+		}
+		if err := gameMap.checkMove(unit.Location, path, ruleset.moveBudget(unit.Rank, gameMap)); err != nil {
+			return CommandRequest{}, fmt.Errorf("error: unit %v can not move: %w", unitID, err)
 		}
 		unitIDs = append(unitIDs, unitID)
 	}
 
+	newLocation := path[len(path)-1]
+	fmt.Printf("Requested a move of %v units to %s\n", len(unitIDs), newLocation)
+	return CommandRequest{
+		GameID:     gs.GetGameID(),
+		Username:   gs.GetUsername(),
+		Command:    CommandKindMove,
+		UnitIDs:    unitIDs,
+		Path:       path,
+		ToLocation: newLocation,
+	}, nil
+}
+
+// moveUnits moves the units along path. Either every unit can make the move or none of them move.
+func (gs *GameState) moveUnits(unitIDs []int, path []Location) ([]Unit, error) {
+	newUnits, err := gs.planMove(unitIDs, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, unit := range newUnits {
+		gs.UpdateUnit(unit)
+	}
+	return newUnits, nil
+}
+
+// planMove checks that the units can move along path and returns them at the end of it, without
+// moving them yet.
+func (gs *GameState) planMove(unitIDs []int, path []Location) ([]Unit, error) {
+	if len(path) == 0 {
+		return nil, errors.New("no location to move to")
+	}
+	if len(unitIDs) == 0 {
+		return nil, errors.New("no units to move")
+	}
+
+	gameMap := gs.GetMap()
+	ruleset := gs.GetRuleset()
+	newLocation := path[len(path)-1]
 	newUnits := []Unit{}
+	seen := map[int]bool{}
 	for _, unitID := range unitIDs {
+		if seen[unitID] {
+			continue
+		}
+		seen[unitID] = true
 		unit, ok := gs.GetUnit(unitID)
 		if !ok {
-			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+			return nil, fmt.Errorf("unit with ID %v not found", unitID)
+		}
+		if err := gameMap.checkMove(unit.Location, path, ruleset.moveBudget(unit.Rank, gameMap)); err != nil {
+			return nil, fmt.Errorf("unit %v can not move: %w", unitID, err)
 		}
 		unit.Location = newLocation
-		gs.UpdateUnit(unit)
 		newUnits = append(newUnits, unit)
 	}
-
-	mv := ArmyMove{
-		ToLocation: newLocation,
-		Units:      newUnits,
-		Player:     gs.GetPlayerSnap(),
-	}
-	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
-	return mv, nil
+	return newUnits, nil
 }
@@ -9,6 +9,10 @@ import (
 func (gs *GameState) HandlePause(ps routing.PlayingState) {
 	defer fmt.Println("------------------------")
 	fmt.Println()
+	if ps.Event != "" {
+		gs.handleTurn(ps)
+		return
+	}
 	if ps.IsPaused {
 		fmt.Println("==== Pause Detected ====")
 		gs.pauseGame()
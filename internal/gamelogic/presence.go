@@ -0,0 +1,111 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// presenceTimeout is how long a player can go without a heartbeat before they count as gone.
+const presenceTimeout = 3 * routing.HeartbeatInterval
+
+// Presence is what the server knows about whether a player is still connected.
+type Presence struct {
+	Username  string
+	LastSeen  time.Time
+	Connected bool
+}
+
+// SetFreezeDisconnected sets whether the units of disconnected players are kept out of wars and
+// stop earning income until the players come back.
+func (w *World) SetFreezeDisconnected(freeze bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.freezeDisconnected = freeze
+}
+
+// HandleHeartbeat records that a player was seen. It returns the delta announcing the player if
+// they just joined, came back or left. Heartbeats of players who are not members are ignored.
+func (w *World) HandleHeartbeat(hb routing.Heartbeat, now time.Time) (StateDelta, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.members[hb.Username] {
+		return StateDelta{}, false
+	}
+	p, ok := w.presence[hb.Username]
+	if !ok {
+		p = &Presence{Username: hb.Username}
+		w.presence[hb.Username] = p
+	}
+	if hb.Leaving {
+		if !p.Connected {
+			return StateDelta{}, false
+		}
+		p.Connected = false
+		return StateDelta{Kind: DeltaKindLeft, Username: hb.Username, Reason: "quit"}, true
+	}
+	p.LastSeen = now
+	if p.Connected {
+		return StateDelta{}, false
+	}
+	p.Connected = true
+	w.player(hb.Username)
+	return StateDelta{Kind: DeltaKindJoined, Username: hb.Username}, true
+}
+
+// CheckPresence marks the players who have stopped sending heartbeats as disconnected and returns
+// the deltas announcing they left.
+func (w *World) CheckPresence(now time.Time) []StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	deltas := []StateDelta{}
+	for _, p := range w.sortedPresence() {
+		if p.Connected && now.Sub(p.LastSeen) > presenceTimeout {
+			p.Connected = false
+			deltas = append(deltas, StateDelta{Kind: DeltaKindLeft, Username: p.Username, Reason: "timed out"})
+		}
+	}
+	return deltas
+}
+
+// Roster lists every player who has sent a heartbeat, in name order.
+func (w *World) Roster() []Presence {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	roster := []Presence{}
+	for _, p := range w.sortedPresence() {
+		roster = append(roster, *p)
+	}
+	return roster
+}
+
+func (w *World) sortedPresence() []*Presence {
+	presence := make([]*Presence, 0, len(w.presence))
+	for _, p := range w.presence {
+		presence = append(presence, p)
+	}
+	sort.Slice(presence, func(i, j int) bool { return presence[i].Username < presence[j].Username })
+	return presence
+}
+
+// frozen reports whether username's units are out of play because the player disconnected. Players
+// who never sent a heartbeat are never frozen.
+func (w *World) frozen(username string) bool {
+	p, ok := w.presence[username]
+	return w.freezeDisconnected && ok && !p.Connected
+}
+
+func (gs *GameState) handlePresence(delta StateDelta) {
+	if delta.Username == gs.GetUsername() {
+		return
+	}
+	if delta.Kind == DeltaKindJoined {
+		fmt.Printf("%s joined the game.\n", delta.Username)
+		return
+	}
+	fmt.Printf("%s left the game (%s).\n", delta.Username, delta.Reason)
+}
@@ -0,0 +1,280 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// RulesetEnv names a JSON ruleset file for the server to play with instead of the default ranks.
+const RulesetEnv = "PERIL_RULESET"
+
+// Combat engines a ruleset can use.
+const (
+	// CombatPower compares the total power of both sides. The bigger the difference, the more of the
+	// weaker side's units die and the fewer of the stronger side's, weakest units first.
+	CombatPower = "power"
+	// CombatDice fights rounds of dice, with stronger units rolling bigger dice, until one side has
+	// no units left. Each lost roll kills one unit.
+	CombatDice = "dice"
+)
+
+// Special abilities a rank can have.
+const (
+	// AbilityCharge doubles the power of the unit when it attacks.
+	AbilityCharge = "charge"
+	// AbilityFortify doubles the power of the unit when it defends.
+	AbilityFortify = "fortify"
+)
+
+// RankDefinition describes one rank of unit. Speed is how much movement cost the unit can cover in
+// one move, where 0 means the map's move points.
+type RankDefinition struct {
+	Name      UnitRank `json:"name"`
+	Power     int      `json:"power"`
+	Cost      int      `json:"cost"`
+	Speed     int      `json:"speed"`
+	Abilities []string `json:"abilities,omitempty"`
+}
+
+func (rd RankDefinition) HasAbility(ability string) bool {
+	for _, a := range rd.Abilities {
+		if a == ability {
+			return true
+		}
+	}
+	return false
+}
+
+// Economy sets the resources players start with and earn on every tick, on top of the income of the
+// regions they control.
+type Economy struct {
+	StartingResources int `json:"starting_resources"`
+	BaseIncome        int `json:"base_income"`
+}
+
+// Victory sets how the game can be won: by controlling ControlRegions regions, by being the last
+// player with units or the resources to spawn one when EliminateOpponents is set, or by having the
+// highest score once TimeLimitMinutes have passed. A zero value turns a condition off.
+type Victory struct {
+	ControlRegions     int  `json:"control_regions"`
+	EliminateOpponents bool `json:"eliminate_opponents"`
+	TimeLimitMinutes   int  `json:"time_limit_minutes"`
+}
+
+// Ruleset is the set of ranks units can have, how their wars are fought, what they cost and how the
+// game is won.
+type Ruleset struct {
+	ranks   []RankDefinition
+	index   map[UnitRank]RankDefinition
+	combat  string
+	economy Economy
+	victory Victory
+}
+
+type rulesetFile struct {
+	Ranks   []RankDefinition `json:"ranks"`
+	Combat  string           `json:"combat,omitempty"`
+	Economy *Economy         `json:"economy,omitempty"`
+	Victory *Victory         `json:"victory,omitempty"`
+}
+
+// defaultEconomy and defaultVictory are used by rulesets that do not set them.
+var (
+	defaultEconomy = Economy{StartingResources: 10, BaseIncome: 1}
+	defaultVictory = Victory{ControlRegions: 4, EliminateOpponents: true}
+)
+
+// NewRuleset creates a ruleset. An empty combat engine means CombatPower.
+func NewRuleset(ranks []RankDefinition, combat string, economy Economy, victory Victory) (*Ruleset, error) {
+	if len(ranks) == 0 {
+		return nil, errors.New("ruleset has no ranks")
+	}
+	if combat == "" {
+		combat = CombatPower
+	}
+	if combat != CombatPower && combat != CombatDice {
+		return nil, fmt.Errorf("unknown combat engine %q", combat)
+	}
+	if economy.StartingResources < 0 {
+		return nil, errors.New("starting resources can not be negative")
+	}
+	if economy.BaseIncome < 0 {
+		return nil, errors.New("base income can not be negative")
+	}
+	if victory.ControlRegions < 0 {
+		return nil, errors.New("the number of regions to control can not be negative")
+	}
+	if victory.TimeLimitMinutes < 0 {
+		return nil, errors.New("the time limit can not be negative")
+	}
+	rs := &Ruleset{
+		ranks:   append([]RankDefinition{}, ranks...),
+		index:   map[UnitRank]RankDefinition{},
+		combat:  combat,
+		economy: economy,
+		victory: victory,
+	}
+	for _, rank := range ranks {
+		if rank.Name == "" {
+			return nil, errors.New("rank names can not be empty")
+		}
+		if _, ok := rs.index[rank.Name]; ok {
+			return nil, fmt.Errorf("rank %s is listed twice", rank.Name)
+		}
+		if rank.Power < 0 {
+			return nil, fmt.Errorf("rank %s can not have negative power", rank.Name)
+		}
+		if rank.Cost < 0 {
+			return nil, fmt.Errorf("rank %s can not have a negative cost", rank.Name)
+		}
+		if rank.Speed < 0 {
+			return nil, fmt.Errorf("rank %s can not have a negative speed", rank.Name)
+		}
+		for _, ability := range rank.Abilities {
+			if ability != AbilityCharge && ability != AbilityFortify {
+				return nil, fmt.Errorf("rank %s has unknown ability %q", rank.Name, ability)
+			}
+		}
+		rs.index[rank.Name] = rank
+	}
+	return rs, nil
+}
+
+// DefaultRuleset is the classic infantry, cavalry and artillery.
+func DefaultRuleset() *Ruleset {
+	rs, err := NewRuleset([]RankDefinition{
+		{Name: RankInfantry, Power: 1, Cost: 1},
+		{Name: RankCavalry, Power: 5, Cost: 4},
+		{Name: RankArtillery, Power: 10, Cost: 8},
+	}, CombatPower, defaultEconomy, defaultVictory)
+	if err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+func LoadRuleset(path string) (*Ruleset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ruleset: %w", err)
+	}
+	var rs Ruleset
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("invalid ruleset %s: %w", path, err)
+	}
+	return &rs, nil
+}
+
+// LoadRulesetFromEnv loads the ruleset named by env, or returns the default ruleset if env is not set.
+func LoadRulesetFromEnv(env string) (*Ruleset, error) {
+	path := os.Getenv(env)
+	if path == "" {
+		return DefaultRuleset(), nil
+	}
+	return LoadRuleset(path)
+}
+
+func (rs *Ruleset) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rulesetFile{Ranks: rs.ranks, Combat: rs.combat, Economy: &rs.economy, Victory: &rs.victory})
+}
+
+func (rs *Ruleset) UnmarshalJSON(data []byte) error {
+	var f rulesetFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	economy := defaultEconomy
+	if f.Economy != nil {
+		economy = *f.Economy
+	}
+	victory := defaultVictory
+	if f.Victory != nil {
+		victory = *f.Victory
+	}
+	loaded, err := NewRuleset(f.Ranks, f.Combat, economy, victory)
+	if err != nil {
+		return err
+	}
+	*rs = *loaded
+	return nil
+}
+
+// Ranks returns the rank definitions in the order of the ruleset.
+func (rs *Ruleset) Ranks() []RankDefinition {
+	return append([]RankDefinition{}, rs.ranks...)
+}
+
+func (rs *Ruleset) Rank(name UnitRank) (RankDefinition, bool) {
+	rank, ok := rs.index[name]
+	return rank, ok
+}
+
+// cheapestCost is what the cheapest rank costs to spawn.
+func (rs *Ruleset) cheapestCost() int {
+	cheapest := rs.ranks[0].Cost
+	for _, rank := range rs.ranks[1:] {
+		cheapest = min(cheapest, rank.Cost)
+	}
+	return cheapest
+}
+
+func (rs *Ruleset) Economy() Economy {
+	return rs.economy
+}
+
+func (rs *Ruleset) Victory() Victory {
+	return rs.victory
+}
+
+func (rs *Ruleset) Combat() string {
+	return rs.combat
+}
+
+// PowerLevel adds up the power of the units, counting the abilities that apply to an attack or a
+// defence.
+func (rs *Ruleset) PowerLevel(units []Unit, attacking bool) int {
+	power := 0
+	for _, unit := range units {
+		power += rs.unitPower(unit, attacking)
+	}
+	return power
+}
+
+func (rs *Ruleset) unitPower(unit Unit, attacking bool) int {
+	rank, ok := rs.index[unit.Rank]
+	if !ok {
+		return 0
+	}
+	power := rank.Power
+	if attacking && rank.HasAbility(AbilityCharge) {
+		power *= 2
+	}
+	if !attacking && rank.HasAbility(AbilityFortify) {
+		power *= 2
+	}
+	return power
+}
+
+// moveBudget is how much movement cost a unit of rank can cover in one move on gameMap.
+func (rs *Ruleset) moveBudget(rank UnitRank, gameMap *Map) int {
+	if definition, ok := rs.index[rank]; ok && definition.Speed > 0 {
+		return definition.Speed
+	}
+	return gameMap.MovePoints()
+}
+
+// CheckMap makes sure every rank can cross every edge of gameMap, so no region is out of reach of
+// any unit.
+func (rs *Ruleset) CheckMap(gameMap *Map) error {
+	for _, rank := range rs.ranks {
+		budget := rs.moveBudget(rank.Name, gameMap)
+		for _, edge := range gameMap.edges {
+			if edge.Cost > budget {
+				return fmt.Errorf("rank %s can cover a cost of %d in one move, but the edge between %s and %s costs %d", rank.Name, budget, edge.From, edge.To, edge.Cost)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestShippedRulesetsFitTheMaps(t *testing.T) {
+	paths, err := filepath.Glob("../../rulesets/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no rulesets found: %v", err)
+	}
+	worldMap, err := LoadMap("../../maps/world.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		rs, err := LoadRuleset(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		for _, gameMap := range []*Map{DefaultMap(), worldMap} {
+			if err := rs.CheckMap(gameMap); err != nil {
+				t.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+}
+
+func TestCheckMapRejectsSlowRanks(t *testing.T) {
+	rs, err := NewRuleset([]RankDefinition{
+		{Name: RankInfantry, Power: 1, Cost: 1, Speed: 1},
+		{Name: RankCavalry, Power: 5, Cost: 4},
+	}, CombatPower, defaultEconomy, defaultVictory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.CheckMap(DefaultMap()); err == nil {
+		t.Error("accepted infantry that can not cross the edges of cost 2")
+	}
+}
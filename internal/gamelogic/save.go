@@ -0,0 +1,147 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// saveVersion is the version of the save file format. Saves of other versions are not loaded.
+const saveVersion = 1
+
+type savedGame struct {
+	Version int
+	SavedAt time.Time
+	State   *GameState
+}
+
+// SavePath is where the client keeps the state of username between sessions.
+func SavePath(username string) string {
+	return fmt.Sprintf("peril_save_%s.json", username)
+}
+
+// Save writes the state to path. The file is replaced in one step, so a crash never leaves half a
+// save behind.
+func (gs *GameState) Save(path string) error {
+	gs.mu.RLock()
+	data, err := json.MarshalIndent(savedGame{Version: saveVersion, SavedAt: time.Now(), State: gs}, "", "  ")
+	gs.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("could not encode game state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("could not write save: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("could not write save: %w", err)
+	}
+	return nil
+}
+
+// LoadGameState reads a state written by Save. It returns an error wrapping os.ErrNotExist if there
+// is no save.
+func LoadGameState(path string) (*GameState, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("could not read save: %w", err)
+	}
+	saved := savedGame{State: NewGameState("")}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid save %s: %w", path, err)
+	}
+	if saved.Version != saveVersion {
+		return nil, time.Time{}, fmt.Errorf("save %s has version %d, expected %d", path, saved.Version, saveVersion)
+	}
+	gs := saved.State
+	if gs.Player.Units == nil {
+		gs.Player.Units = map[int]Unit{}
+	}
+	if gs.Opponents == nil {
+		gs.Opponents = map[string]Player{}
+	}
+	if gs.Map == nil {
+		gs.Map = DefaultMap()
+	}
+	if gs.Ruleset == nil {
+		gs.Ruleset = DefaultRuleset()
+	}
+	return gs, saved.SavedAt, nil
+}
+
+// resumePoint is the player's side of a resumed save.
+type resumePoint struct {
+	GameID    string
+	Units     map[int]Unit
+	Resources int
+}
+
+// offerResume asks whether to pick up the saved session of username. It returns nil to start over.
+// The server's state always wins: the save takes the player back to their game, and what changed
+// there since is reported once the server's snapshot replaces it.
+func offerResume(username string) *GameState {
+	gs, savedAt, err := LoadGameState(SavePath(username))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		fmt.Println("Could not load your last session:", err)
+		return nil
+	}
+	if gs.GetUsername() != username {
+		return nil
+	}
+	where := "the lobby"
+	if gs.GameID != "" {
+		where = "game " + gs.GameID
+	}
+	fmt.Printf("You were last in %s with %d unit(s), saved at %s.\n", where, len(gs.Player.Units), savedAt.Format("2006-01-02 15:04:05"))
+	fmt.Println("Resume that session? (y/n)")
+	words := GetInput()
+	if len(words) == 0 || (words[0] != "y" && words[0] != "yes") {
+		return nil
+	}
+	if gs.GameID != "" {
+		gs.resumed = &resumePoint{GameID: gs.GameID, Units: gs.GetPlayerSnap().Units, Resources: gs.Resources}
+	}
+	// Whether the game is paused comes with the snapshot, the saved flag may be stale.
+	gs.Paused = false
+	return gs
+}
+
+// reportResume tells the player how the snapshot they just got differs from the save they resumed.
+func (gs *GameState) reportResume() {
+	gs.mu.Lock()
+	resumed := gs.resumed
+	gs.resumed = nil
+	gameID := gs.GameID
+	units := gs.Player.Units
+	resources := gs.Resources
+	gs.mu.Unlock()
+	if resumed == nil || resumed.GameID != gameID {
+		return
+	}
+
+	lost, moved := 0, 0
+	for id, saved := range resumed.Units {
+		unit, ok := units[id]
+		if !ok {
+			lost++
+		} else if unit.Location != saved.Location {
+			moved++
+		}
+	}
+	gained := 0
+	for id := range units {
+		if _, ok := resumed.Units[id]; !ok {
+			gained++
+		}
+	}
+	if lost == 0 && moved == 0 && gained == 0 && resources == resumed.Resources {
+		fmt.Println("Nothing changed since your save.")
+		return
+	}
+	fmt.Printf("Since your save, %d unit(s) were lost, %d moved and %d are new, and your resources went from %d to %d.\n", lost, moved, gained, resumed.Resources, resources)
+}
@@ -5,30 +5,71 @@ import (
 	"fmt"
 )
 
-func (gs *GameState) CommandSpawn(words []string) error {
+func (gs *GameState) CommandSpawn(words []string) (CommandRequest, error) {
+	if gs.GetGameID() == "" {
+		return CommandRequest{}, errors.New("join a game before spawning units")
+	}
+	if gs.IsGameOver() {
+		return CommandRequest{}, errors.New("the game is over, you can not spawn units")
+	}
 	if len(words) < 3 {
-		return errors.New("usage: spawn <location> <rank>")
+		return CommandRequest{}, errors.New("usage: spawn <location> <rank>")
 	}
 
 	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
-	}
-
 	rank := words[2]
-	units := getAllRanks()
-	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+	if err := gs.validateSpawn(Location(locationName), UnitRank(rank)); err != nil {
+		return CommandRequest{}, err
+	}
+	if err := gs.checkResources(UnitRank(rank)); err != nil {
+		return CommandRequest{}, err
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
-		ID:       id,
-		Rank:     UnitRank(rank),
+	fmt.Printf("Requested a(n) %s in %s\n", rank, locationName)
+	return CommandRequest{
+		GameID:   gs.GetGameID(),
+		Username: gs.GetUsername(),
+		Command:  CommandKindSpawn,
 		Location: Location(locationName),
-	})
+		Rank:     UnitRank(rank),
+	}, nil
+}
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
+func (gs *GameState) validateSpawn(location Location, rank UnitRank) error {
+	if !gs.GetMap().HasRegion(location) {
+		return fmt.Errorf("error: %s is not a valid location", location)
+	}
+	if _, ok := gs.GetRuleset().Rank(rank); !ok {
+		return fmt.Errorf("error: %s is not a valid unit", rank)
+	}
+	return nil
+}
+
+// checkResources makes sure the player can afford a unit of rank.
+func (gs *GameState) checkResources(rank UnitRank) error {
+	definition, _ := gs.GetRuleset().Rank(rank)
+	if resources := gs.GetResources(); definition.Cost > resources {
+		return fmt.Errorf("error: not enough resources for a(n) %s: need %d, have %d", rank, definition.Cost, resources)
+	}
 	return nil
 }
+
+// spawnUnit pays for and adds a new unit with the next free ID.
+func (gs *GameState) spawnUnit(location Location, rank UnitRank) (Unit, error) {
+	if err := gs.validateSpawn(location, rank); err != nil {
+		return Unit{}, err
+	}
+	if err := gs.checkResources(rank); err != nil {
+		return Unit{}, err
+	}
+	definition, _ := gs.GetRuleset().Rank(rank)
+	gs.SetResources(gs.GetResources() - definition.Cost)
+	u := Unit{
+		ID:       gs.allocateUnitID(),
+		Owner:    gs.GetUsername(),
+		Rank:     rank,
+		Location: location,
+	}
+	gs.addUnit(u)
+	return u, nil
+}
@@ -0,0 +1,56 @@
+package gamelogic
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// handleTurn follows the server's turn clock. Orders only last until the end of their turn.
+func (gs *GameState) handleTurn(ps routing.PlayingState) {
+	gs.mu.Lock()
+	gs.Turn = ps.Turn
+	gs.Orders = nil
+	gs.mu.Unlock()
+
+	switch ps.Event {
+	case routing.TurnStarted:
+		fmt.Printf("==== Turn %d Started ====\n", ps.Turn)
+		fmt.Printf("Give your orders before %s.\n", ps.TurnEnds.Format("15:04:05"))
+	case routing.TurnEnded:
+		fmt.Printf("==== Turn %d Ended ====\n", ps.Turn)
+	}
+}
+
+// queueOrder records a move the server accepted for the end of the turn, replacing any earlier
+// order for the same units.
+func (gs *GameState) queueOrder(delta StateDelta) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	ordered := map[int]bool{}
+	order := CommandRequest{Username: delta.Username, Command: CommandKindMove, ToLocation: delta.ToLocation}
+	for _, unit := range delta.Units {
+		ordered[unit.ID] = true
+		order.UnitIDs = append(order.UnitIDs, unit.ID)
+	}
+	orders := []CommandRequest{}
+	for _, earlier := range gs.Orders {
+		kept := []int{}
+		for _, id := range earlier.UnitIDs {
+			if !ordered[id] {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) > 0 {
+			earlier.UnitIDs = kept
+			orders = append(orders, earlier)
+		}
+	}
+	gs.Orders = append(orders, order)
+}
+
+func (gs *GameState) getOrders() (int, []CommandRequest) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Turn, append([]CommandRequest{}, gs.Orders...)
+}
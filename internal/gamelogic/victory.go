@@ -0,0 +1,195 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Ways a game can be won.
+const (
+	VictoryControl     = "control"
+	VictoryElimination = "elimination"
+	VictoryTimeLimit   = "time_limit"
+)
+
+// regionScore is what every controlled region adds to a player's score, on top of the power of
+// their units.
+const regionScore = 10
+
+// Standing is where a player finished.
+type Standing struct {
+	Username  string
+	Score     int
+	Regions   int
+	Units     int
+	Resources int
+}
+
+// GameOver is how the game ended. Winner is empty if the time ran out on a tied score.
+type GameOver struct {
+	Winner    string
+	Reason    string
+	Standings []Standing
+}
+
+// computeStandings ranks the players by score, highest first.
+func computeStandings(ruleset *Ruleset, players []Player, resources map[string]int) []Standing {
+	regions := map[string]int{}
+	for _, username := range regionControllers(players) {
+		regions[username]++
+	}
+	standings := []Standing{}
+	for _, player := range players {
+		standing := Standing{
+			Username:  player.Username,
+			Regions:   regions[player.Username],
+			Units:     len(player.Units),
+			Resources: resources[player.Username],
+		}
+		for _, unit := range player.Units {
+			rank, _ := ruleset.Rank(unit.Rank)
+			standing.Score += rank.Power
+		}
+		standing.Score += standing.Regions * regionScore
+		standings = append(standings, standing)
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		return standings[i].Username < standings[j].Username
+	})
+	return standings
+}
+
+// CheckVictory ends the game once a player meets one of the ruleset's victory conditions. Nobody
+// can win before two players have spawned units. It only returns true the one time the game ends,
+// with the delta announcing it.
+func (w *World) CheckVictory(now time.Time) (StateDelta, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.gameOver != nil || w.paused || len(w.fielded) < 2 {
+		return StateDelta{}, false
+	}
+	players := make([]Player, 0, len(w.players))
+	for _, gs := range w.players {
+		players = append(players, gs.GetPlayerSnap())
+	}
+	standings := computeStandings(w.ruleset, players, w.resources())
+	victory := w.ruleset.Victory()
+
+	var over *GameOver
+	if victory.ControlRegions > 0 {
+		for _, standing := range standings {
+			if standing.Regions >= victory.ControlRegions {
+				over = &GameOver{Winner: standing.Username, Reason: VictoryControl}
+				break
+			}
+		}
+	}
+	if over == nil && victory.EliminateOpponents {
+		// A player without units is only out once they can not afford to spawn another.
+		survivors := []string{}
+		for _, standing := range standings {
+			if standing.Units > 0 || standing.Resources >= w.ruleset.cheapestCost() {
+				survivors = append(survivors, standing.Username)
+			}
+		}
+		if len(survivors) == 1 {
+			over = &GameOver{Winner: survivors[0], Reason: VictoryElimination}
+		}
+	}
+	if over == nil && victory.TimeLimitMinutes > 0 && len(standings) > 0 &&
+		now.Sub(w.started) >= time.Duration(victory.TimeLimitMinutes)*time.Minute {
+		over = &GameOver{Reason: VictoryTimeLimit}
+		if len(standings) == 1 || standings[0].Score > standings[1].Score {
+			over.Winner = standings[0].Username
+		}
+	}
+	if over == nil {
+		return StateDelta{}, false
+	}
+	over.Standings = standings
+	w.gameOver = over
+	w.ended = now
+
+	w.seq++
+	return StateDelta{
+		Seq:      w.seq,
+		Kind:     DeltaKindGameOver,
+		Username: over.Winner,
+		GameOver: over,
+	}, true
+}
+
+// Restart starts a game that has been over for at least after again. Every player stays in the game
+// with the starting resources and no units, and the clock starts over. It returns false while the
+// game is still being played, and otherwise the delta that resets the players.
+func (w *World) Restart(now time.Time, after time.Duration) (StateDelta, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.gameOver == nil || now.Sub(w.ended) < after {
+		return StateDelta{}, false
+	}
+	usernames := make([]string, 0, len(w.players))
+	for username := range w.players {
+		usernames = append(usernames, username)
+	}
+	w.players = map[string]*GameState{}
+	w.started = time.Time{}
+	w.ended = time.Time{}
+	for _, username := range usernames {
+		w.player(username)
+	}
+	w.fielded = map[string]bool{}
+	w.orders = nil
+	w.tick = 0
+	w.gameOver = nil
+
+	delta := w.snapshot()
+	delta.Kind = DeltaKindRestored
+	delta.Reason = "the start of a new game"
+	return delta, true
+}
+
+func (gs *GameState) GetGameOver() *GameOver {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.GameOver
+}
+
+func (gs *GameState) IsGameOver() bool {
+	return gs.GetGameOver() != nil
+}
+
+func (gs *GameState) handleGameOver(over *GameOver) {
+	gs.mu.Lock()
+	gs.GameOver = over
+	gs.mu.Unlock()
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	fmt.Println(over.Summary())
+	fmt.Println("Final standings:")
+	for i, standing := range over.Standings {
+		fmt.Printf("%d. %s: score %d (%d region(s), %d unit(s), %d resources)\n", i+1, standing.Username, standing.Score, standing.Regions, standing.Units, standing.Resources)
+	}
+}
+
+// Summary says who won and how.
+func (over GameOver) Summary() string {
+	switch {
+	case over.Winner == "":
+		return "The time ran out with the top players tied, the game is a draw."
+	case over.Reason == VictoryControl:
+		return fmt.Sprintf("%s won by taking control of enough regions!", over.Winner)
+	case over.Reason == VictoryElimination:
+		return fmt.Sprintf("%s won by eliminating every opponent!", over.Winner)
+	default:
+		return fmt.Sprintf("%s won with the highest score when the time ran out!", over.Winner)
+	}
+}
@@ -0,0 +1,90 @@
+package gamelogic
+
+import (
+	"testing"
+	"time"
+)
+
+func victoryWorld(t *testing.T, victory Victory) *World {
+	t.Helper()
+	rs, err := NewRuleset(DefaultRuleset().Ranks(), CombatPower, defaultEconomy, victory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWorld("test", DefaultMap(), rs)
+}
+
+func TestNobodyWinsAlone(t *testing.T) {
+	w := victoryWorld(t, Victory{ControlRegions: 1, EliminateOpponents: true})
+	spawn(t, w, "alice", "asia")
+	if _, ok := w.CheckVictory(time.Now()); ok {
+		t.Fatal("alice won a game nobody else played")
+	}
+	spawn(t, w, "bob", "australia")
+	delta, ok := w.CheckVictory(time.Now())
+	if !ok || delta.GameOver.Winner != "alice" || delta.GameOver.Reason != VictoryControl {
+		t.Errorf("got %+v, expected alice to win by control", delta.GameOver)
+	}
+}
+
+func TestEliminationWaitsForRespawns(t *testing.T) {
+	w := victoryWorld(t, Victory{EliminateOpponents: true})
+	spawn(t, w, "alice", "asia")
+	spawn(t, w, "bob", "australia")
+	bob := w.players["bob"]
+	bob.Player.Units = map[int]Unit{}
+	if delta, ok := w.CheckVictory(time.Now()); ok {
+		t.Fatalf("got %+v, expected bob to still be in with %d resources", delta.GameOver, bob.GetResources())
+	}
+
+	bob.SetResources(w.ruleset.cheapestCost() - 1)
+	delta, ok := w.CheckVictory(time.Now())
+	if !ok || delta.GameOver.Winner != "alice" || delta.GameOver.Reason != VictoryElimination {
+		t.Errorf("got %+v, expected alice to win once bob can not spawn", delta.GameOver)
+	}
+}
+
+func TestTimeLimitStartsWithFirstPlayer(t *testing.T) {
+	w := victoryWorld(t, Victory{TimeLimitMinutes: 10})
+	if !w.started.IsZero() {
+		t.Fatal("the clock started before anyone joined")
+	}
+	spawn(t, w, "alice", "asia")
+	spawn(t, w, "bob", "europe")
+	if _, ok := w.CheckVictory(time.Now().Add(9 * time.Minute)); ok {
+		t.Error("the game ended before the time limit")
+	}
+	delta, ok := w.CheckVictory(time.Now().Add(10 * time.Minute))
+	if !ok || delta.GameOver.Reason != VictoryTimeLimit || delta.GameOver.Winner != "" {
+		t.Errorf("got %+v, expected a draw on time with the scores tied", delta.GameOver)
+	}
+}
+
+func TestRestartFinishedGame(t *testing.T) {
+	w := victoryWorld(t, Victory{ControlRegions: 1})
+	spawn(t, w, "alice", "asia")
+	spawn(t, w, "bob", "australia")
+	ended := time.Now()
+	if _, ok := w.CheckVictory(ended); !ok {
+		t.Fatal("nobody won")
+	}
+	if _, ok := w.Restart(ended.Add(30*time.Second), time.Minute); ok {
+		t.Fatal("restarted while the final standings were still showing")
+	}
+
+	delta, ok := w.Restart(ended.Add(time.Minute), time.Minute)
+	if !ok {
+		t.Fatal("the finished game did not start over")
+	}
+	if delta.Kind != DeltaKindRestored || len(delta.Units) != 0 || delta.GameOver != nil {
+		t.Errorf("got %+v, expected an empty world to reset to", delta)
+	}
+	starting := w.ruleset.Economy().StartingResources
+	if delta.Resources["alice"] != starting || delta.Resources["bob"] != starting {
+		t.Errorf("got resources %v, expected everyone back to %d", delta.Resources, starting)
+	}
+	if _, ok := w.CheckVictory(time.Now()); ok {
+		t.Error("the new game was won before anyone spawned")
+	}
+	spawn(t, w, "alice", "asia")
+}
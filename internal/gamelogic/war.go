@@ -2,6 +2,9 @@ package gamelogic
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
+	"sort"
 )
 
 type WarOutcome int
@@ -14,92 +17,349 @@ const (
 	WarOutcomeDraw
 )
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
-	defer fmt.Println("------------------------")
-	fmt.Println()
-	fmt.Println("==== War Declared ====")
-	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
+// Dice rounds in the style of Risk: up to diceAttackers attacking units roll against up to
+// diceDefenders defending units.
+const (
+	diceAttackers = 3
+	diceDefenders = 2
+	diceSides     = 6
+)
 
-	player := gs.GetPlayerSnap()
+// resolveWar fights a battle between the attacker's and the defender's units in every location they
+// share, in name order. Each battle rolls with a seed from seeds. It returns the results and the
+// units killed, or false if they share no location.
+func resolveWar(ruleset *Ruleset, attacker, defender Player, seeds func() int64) ([]WarResult, []Unit, bool) {
+	overlappingLocations := getOverlappingLocations(attacker, defender)
+	if len(overlappingLocations) == 0 {
+		return nil, nil, false
+	}
+	results := []WarResult{}
+	killed := []Unit{}
+	for _, location := range overlappingLocations {
+		result, battleKilled := fightBattle(ruleset, WarResult{
+			Attacker:      attacker.Username,
+			Defender:      defender.Username,
+			Location:      location,
+			Combat:        ruleset.Combat(),
+			Seed:          seeds(),
+			AttackerUnits: unitsInLocation(attacker, location),
+			DefenderUnits: unitsInLocation(defender, location),
+		})
+		results = append(results, result)
+		killed = append(killed, battleKilled...)
+	}
+	return results, killed, true
+}
 
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+// unitsInLocation returns the player's units in location, ordered by ID.
+func unitsInLocation(player Player, location Location) []Unit {
+	units := []Unit{}
+	for _, unit := range player.Units {
+		if unit.Location == location {
+			units = append(units, unit)
+		}
 	}
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+	return units
+}
 
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+// fightBattle fights the battle set up in result with its combat engine and seed, and returns the
+// completed result with the units killed.
+func fightBattle(ruleset *Ruleset, result WarResult) (WarResult, []Unit) {
+	result.AttackerPower = ruleset.PowerLevel(result.AttackerUnits, true)
+	result.DefenderPower = ruleset.PowerLevel(result.DefenderUnits, false)
+	var killed []Unit
+	if result.Combat == CombatDice {
+		result, killed = fightDice(ruleset, result)
+	} else {
+		result, killed = fightPower(ruleset, result)
 	}
+	result.Report = newBattleReport(result, killed)
+	return result, killed
+}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+// fightPower kills a share of each side's units that depends on how far apart their power is. With
+// equal power both sides lose half their units. The further apart they are, the more the weaker
+// side loses and the less the stronger side does; the weaker side always loses at least one unit.
+func fightPower(ruleset *Ruleset, result WarResult) (WarResult, []Unit) {
+	result.Rounds = 1
+	total := result.AttackerPower + result.DefenderPower
+	difference := result.AttackerPower - result.DefenderPower
+	if difference < 0 {
+		difference = -difference
+	}
+	share := 0.0
+	if total > 0 {
+		share = float64(difference) / float64(total)
 	}
+	winnerRatio := math.Max(0, 0.5-share)
+	loserRatio := math.Min(1, 0.5+share)
+
+	switch {
+	case result.AttackerPower > result.DefenderPower:
+		result.Winner, result.Loser = result.Attacker, result.Defender
+		killed := casualties(ruleset, result.AttackerUnits, true, winnerRatio, false)
+		return result, append(killed, casualties(ruleset, result.DefenderUnits, false, loserRatio, true)...)
+	case result.DefenderPower > result.AttackerPower:
+		result.Winner, result.Loser = result.Defender, result.Attacker
+		killed := casualties(ruleset, result.AttackerUnits, true, loserRatio, true)
+		return result, append(killed, casualties(ruleset, result.DefenderUnits, false, winnerRatio, false)...)
+	default:
+		result.Draw = true
+		killed := casualties(ruleset, result.AttackerUnits, true, 0.5, false)
+		return result, append(killed, casualties(ruleset, result.DefenderUnits, false, 0.5, false)...)
+	}
+}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
+// casualties picks the weakest ratio of the units to die, rounding to the nearest unit.
+func casualties(ruleset *Ruleset, units []Unit, attacking bool, ratio float64, atLeastOne bool) []Unit {
+	count := int(math.Round(ratio * float64(len(units))))
+	if atLeastOne && count == 0 {
+		count = 1
+	}
+	count = min(count, len(units))
+	sorted := strongestFirst(ruleset, units, attacking)
+	return sorted[len(sorted)-count:]
+}
+
+func newBattleReport(result WarResult, killed []Unit) BattleReport {
+	dead := map[UnitKey]bool{}
+	for _, unit := range killed {
+		dead[unit.Key()] = true
+	}
+	report := BattleReport{
+		Location:          result.Location,
+		Attacker:          result.Attacker,
+		Defender:          result.Defender,
+		AttackerLosses:    []Unit{},
+		DefenderLosses:    []Unit{},
+		AttackerSurvivors: withoutUnits(result.AttackerUnits, dead),
+		DefenderSurvivors: withoutUnits(result.DefenderUnits, dead),
+	}
+	for _, unit := range result.AttackerUnits {
+		if dead[unit.Key()] {
+			report.AttackerLosses = append(report.AttackerLosses, unit)
 		}
 	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
+	for _, unit := range result.DefenderUnits {
+		if dead[unit.Key()] {
+			report.DefenderLosses = append(report.DefenderLosses, unit)
 		}
 	}
+	return report
+}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+// Summary describes the losses of both sides in one line.
+func (report BattleReport) Summary() string {
+	return fmt.Sprintf("in %s %s lost %d unit(s) and %s lost %d unit(s)", report.Location,
+		report.Attacker, len(report.AttackerLosses), report.Defender, len(report.DefenderLosses))
+}
+
+func (report BattleReport) Print() {
+	fmt.Printf("Battle report for %s:\n", report.Location)
+	printUnits := func(label string, units []Unit) {
+		fmt.Printf("  %s: %d unit(s)", label, len(units))
+		for i, unit := range units {
+			if i == 0 {
+				fmt.Print(" -")
+			}
+			fmt.Printf(" %v (%v)", unit.Rank, unit.ID)
+		}
+		fmt.Println()
 	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+	printUnits(report.Attacker+" lost", report.AttackerLosses)
+	printUnits(report.Defender+" lost", report.DefenderLosses)
+	printUnits(report.Attacker+" survivors", report.AttackerSurvivors)
+	printUnits(report.Defender+" survivors", report.DefenderSurvivors)
+}
+
+type roll struct {
+	unit  Unit
+	value int
+}
+
+// fightDice fights rounds until one side is wiped out. Each round the strongest units of both
+// sides roll a die with diceSides plus their power sides. The highest rolls are paired up and the
+// lower roll of each pair dies, with ties going to the defender.
+func fightDice(ruleset *Ruleset, result WarResult) (WarResult, []Unit) {
+	rng := rand.New(rand.NewSource(result.Seed))
+	attackers := strongestFirst(ruleset, result.AttackerUnits, true)
+	defenders := strongestFirst(ruleset, result.DefenderUnits, false)
+	killed := []Unit{}
+
+	for len(attackers) > 0 && len(defenders) > 0 {
+		result.Rounds++
+		attackRolls := rollDice(rng, ruleset, attackers[:min(diceAttackers, len(attackers))], true)
+		defenceRolls := rollDice(rng, ruleset, defenders[:min(diceDefenders, len(defenders))], false)
+		dead := map[UnitKey]bool{}
+		for i := 0; i < len(attackRolls) && i < len(defenceRolls); i++ {
+			loser := attackRolls[i].unit
+			if attackRolls[i].value > defenceRolls[i].value {
+				loser = defenceRolls[i].unit
+			}
+			dead[loser.Key()] = true
+			killed = append(killed, loser)
+		}
+		attackers = withoutUnits(attackers, dead)
+		defenders = withoutUnits(defenders, dead)
 	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
+
+	if len(attackers) > 0 {
+		result.Winner, result.Loser = result.Attacker, result.Defender
+	} else {
+		result.Winner, result.Loser = result.Defender, result.Attacker
+	}
+	return result, killed
+}
+
+// strongestFirst orders units by power, then by owner and ID so the order never depends on how
+// they were listed.
+func strongestFirst(ruleset *Ruleset, units []Unit, attacking bool) []Unit {
+	sorted := append([]Unit{}, units...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		pi, pj := ruleset.unitPower(sorted[i], attacking), ruleset.unitPower(sorted[j], attacking)
+		if pi != pj {
+			return pi > pj
 		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
+		if sorted[i].Owner != sorted[j].Owner {
+			return sorted[i].Owner < sorted[j].Owner
 		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// rollDice rolls for each unit in order and returns the rolls, highest first.
+func rollDice(rng *rand.Rand, ruleset *Ruleset, units []Unit, attacking bool) []roll {
+	rolls := []roll{}
+	for _, unit := range units {
+		sides := diceSides + ruleset.unitPower(unit, attacking)
+		rolls = append(rolls, roll{unit: unit, value: rng.Intn(sides) + 1})
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
+	sort.SliceStable(rolls, func(i, j int) bool { return rolls[i].value > rolls[j].value })
+	return rolls
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
+func withoutUnits(units []Unit, dead map[UnitKey]bool) []Unit {
+	alive := []Unit{}
 	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
+		if !dead[unit.Key()] {
+			alive = append(alive, unit)
+		}
+	}
+	return alive
+}
+
+// HandleWarResults reports the server's ruling on each battle of a war from the player's point of
+// view. The killed units are removed when the StateDelta carrying the results is applied.
+func (gs *GameState) HandleWarResults(results []WarResult) []WarOutcome {
+	defer fmt.Println("------------------------")
+	outcomes := []WarOutcome{}
+	if len(results) == 0 {
+		return outcomes
+	}
+	fmt.Println()
+	fmt.Println("==== War Declared ====")
+	fmt.Printf("%s has declared war on %s!\n", results[0].Attacker, results[0].Defender)
+
+	username := gs.GetUsername()
+	if username != results[0].Attacker && username != results[0].Defender {
+		fmt.Printf("%s, you are not involved in this war.\n", username)
+		for range results {
+			outcomes = append(outcomes, WarOutcomeNotInvolved)
 		}
-		if unit.Rank == RankCavalry {
-			power += 5
+		return outcomes
+	}
+	if len(results) > 1 {
+		fmt.Printf("The war is fought on %d fronts.\n", len(results))
+	}
+
+	won, lost, drawn := 0, 0, 0
+	for _, result := range results {
+		outcome := gs.handleBattle(result)
+		switch outcome {
+		case WarOutcomeYouWon:
+			won++
+		case WarOutcomeOpponentWon:
+			lost++
+		case WarOutcomeDraw:
+			drawn++
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	if len(results) > 1 {
+		fmt.Printf("You won %d, lost %d and drew %d battle(s).\n", won, lost, drawn)
+	}
+	return outcomes
+}
+
+func (gs *GameState) handleBattle(result WarResult) WarOutcome {
+	username := gs.GetUsername()
+	fmt.Printf("-- Battle in %s --\n", result.Location)
+	fmt.Printf("%s's units:\n", result.Attacker)
+	for _, unit := range result.AttackerUnits {
+		fmt.Printf("  * %v\n", unit.Rank)
+	}
+	fmt.Printf("%s's units:\n", result.Defender)
+	for _, unit := range result.DefenderUnits {
+		fmt.Printf("  * %v\n", unit.Rank)
+	}
+	fmt.Printf("Attacker has a power level of %v\n", result.AttackerPower)
+	fmt.Printf("Defender has a power level of %v\n", result.DefenderPower)
+	if result.Combat == CombatDice {
+		fmt.Printf("The battle lasted %d round(s).\n", result.Rounds)
+	}
+
+	if result.Draw {
+		fmt.Println("The battle ended in a draw!")
+	} else {
+		fmt.Printf("%s has won the battle!\n", result.Winner)
+		if username == result.Loser {
+			fmt.Println("You have lost the battle!")
 		}
-		if unit.Rank == RankInfantry {
-			power += 1
+	}
+	result.Report.Print()
+
+	switch {
+	case result.Draw:
+		return WarOutcomeDraw
+	case username == result.Loser:
+		return WarOutcomeOpponentWon
+	default:
+		return WarOutcomeYouWon
+	}
+}
+
+// checkWarResults fights the battles of results again with the units the player knows about, and
+// reports whether the same units die.
+func (gs *GameState) checkWarResults(results []WarResult, killed []Unit) bool {
+	replayKilled := []Unit{}
+	for _, result := range results {
+		replay := result
+		replay.AttackerUnits = unitsInLocation(gs.getPlayer(result.Attacker), result.Location)
+		replay.DefenderUnits = unitsInLocation(gs.getPlayer(result.Defender), result.Location)
+		_, battleKilled := fightBattle(gs.GetRuleset(), replay)
+		replayKilled = append(replayKilled, battleKilled...)
+	}
+	if len(replayKilled) != len(killed) {
+		return false
+	}
+	expected := map[UnitKey]bool{}
+	for _, unit := range replayKilled {
+		expected[unit.Key()] = true
+	}
+	for _, unit := range killed {
+		if !expected[unit.Key()] {
+			return false
 		}
 	}
-	return power
+	return true
+}
+
+// LogMessage describes the result of the battle for the game log.
+func (result WarResult) LogMessage() string {
+	if result.Draw {
+		return fmt.Sprintf("A battle between %s and %s resulted in a draw, %s", result.Attacker, result.Defender, result.Report.Summary())
+	}
+	return fmt.Sprintf("%s won a battle against %s, %s", result.Winner, result.Loser, result.Report.Summary())
 }
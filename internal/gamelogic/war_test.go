@@ -0,0 +1,153 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func riskRuleset(t *testing.T) *Ruleset {
+	t.Helper()
+	rs, err := LoadRuleset("../../rulesets/risk.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+// army gives the units to username, numbering them from 1.
+func army(username string, units ...Unit) Player {
+	player := Player{Username: username, Units: map[int]Unit{}}
+	for i, unit := range units {
+		unit.ID = i + 1
+		unit.Owner = username
+		player.Units[unit.ID] = unit
+	}
+	return player
+}
+
+func TestFightDiceWithFixedSeed(t *testing.T) {
+	rs := riskRuleset(t)
+	alice := army("alice",
+		Unit{Rank: RankInfantry, Location: "asia"},
+		Unit{Rank: RankInfantry, Location: "asia"},
+		Unit{Rank: RankCavalry, Location: "asia"},
+		Unit{Rank: RankArtillery, Location: "asia"},
+	)
+	bob := army("bob",
+		Unit{Rank: RankInfantry, Location: "asia"},
+		Unit{Rank: RankInfantry, Location: "asia"},
+		Unit{Rank: RankCavalry, Location: "asia"},
+	)
+	battle := WarResult{
+		Attacker:      "alice",
+		Defender:      "bob",
+		Location:      "asia",
+		Combat:        CombatDice,
+		Seed:          42,
+		AttackerUnits: unitsInLocation(alice, "asia"),
+		DefenderUnits: unitsInLocation(bob, "asia"),
+	}
+
+	result, killed := fightDice(rs, battle)
+	// Three attackers against two defenders kill two units in the first round, and the last
+	// defender falls in the second.
+	if result.Rounds != 2 || result.Winner != "alice" || result.Loser != "bob" {
+		t.Errorf("got %d round(s) won by %s, expected alice to win in 2", result.Rounds, result.Winner)
+	}
+	want := []Unit{
+		{ID: 1, Owner: "bob", Rank: RankInfantry, Location: "asia"},
+		{ID: 3, Owner: "bob", Rank: RankCavalry, Location: "asia"},
+		{ID: 2, Owner: "bob", Rank: RankInfantry, Location: "asia"},
+	}
+	if !reflect.DeepEqual(killed, want) {
+		t.Errorf("killed %v, expected %v", killed, want)
+	}
+
+	again, killedAgain := fightDice(rs, battle)
+	if !reflect.DeepEqual(again, result) || !reflect.DeepEqual(killedAgain, killed) {
+		t.Error("the same seed fought a different battle")
+	}
+}
+
+func TestResolveWarOnEveryFront(t *testing.T) {
+	rs := DefaultRuleset()
+	alice := army("alice",
+		Unit{Rank: RankInfantry, Location: "asia"},
+		Unit{Rank: RankInfantry, Location: "asia"},
+		Unit{Rank: RankArtillery, Location: "europe"},
+	)
+	bob := army("bob",
+		Unit{Rank: RankArtillery, Location: "asia"},
+		Unit{Rank: RankInfantry, Location: "europe"},
+		Unit{Rank: RankInfantry, Location: "africa"},
+	)
+	seed := int64(0)
+	seeds := func() int64 {
+		seed++
+		return seed
+	}
+
+	results, killed, ok := resolveWar(rs, alice, bob, seeds)
+	if !ok || len(results) != 2 {
+		t.Fatalf("got %d battle(s), expected one in asia and one in europe", len(results))
+	}
+	for i, want := range []struct {
+		location Location
+		seed     int64
+		winner   string
+	}{
+		{"asia", 1, "bob"},
+		{"europe", 2, "alice"},
+	} {
+		result := results[i]
+		if result.Location != want.location || result.Seed != want.seed || result.Winner != want.winner {
+			t.Errorf("battle %d: got %s with seed %d won by %s, expected %s with seed %d won by %s", i, result.Location, result.Seed, result.Winner, want.location, want.seed, want.winner)
+		}
+	}
+	// The weaker side loses everything and the far stronger side nothing.
+	wantKilled := []Unit{alice.Units[1], alice.Units[2], bob.Units[2]}
+	if !reflect.DeepEqual(killed, wantKilled) {
+		t.Errorf("killed %v, expected %v", killed, wantKilled)
+	}
+
+	if _, _, ok := resolveWar(rs, alice, army("carol", Unit{Rank: RankInfantry, Location: "australia"}), seeds); ok {
+		t.Error("fought a war between players who share no location")
+	}
+}
+
+func TestResolveWarCanBeReplayed(t *testing.T) {
+	rs := riskRuleset(t)
+	alice := army("alice",
+		Unit{Rank: RankCavalry, Location: "asia"},
+		Unit{Rank: RankInfantry, Location: "asia"},
+		Unit{Rank: RankArtillery, Location: "europe"},
+	)
+	bob := army("bob",
+		Unit{Rank: RankInfantry, Location: "asia"},
+		Unit{Rank: RankInfantry, Location: "europe"},
+		Unit{Rank: RankInfantry, Location: "europe"},
+	)
+	seed := int64(100)
+	results, _, ok := resolveWar(rs, alice, bob, func() int64 {
+		seed += 7
+		return seed
+	})
+	if !ok {
+		t.Fatal("no war was fought")
+	}
+	// Anyone holding a result can fight the same battle again from its seed and units.
+	for _, result := range results {
+		replayed, _ := fightBattle(rs, WarResult{
+			Attacker:      result.Attacker,
+			Defender:      result.Defender,
+			Location:      result.Location,
+			Combat:        result.Combat,
+			Seed:          result.Seed,
+			AttackerUnits: result.AttackerUnits,
+			DefenderUnits: result.DefenderUnits,
+		})
+		if !reflect.DeepEqual(replayed, result) {
+			t.Errorf("replaying the battle in %s gave %+v, expected %+v", result.Location, replayed, result)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// World is the server's authoritative record of every player's units. Players only ask for changes
+// with a CommandRequest; the World checks them and returns the StateDelta to broadcast. In turn mode
+// moves are queued as orders and all resolved together when the turn ends. Once someone has won,
+// the World turns every command down.
+type World struct {
+	id      string
+	gameMap *Map
+	ruleset *Ruleset
+	players map[string]*GameState
+	// members are the players the lobby let into the game. Only they may send commands, and they
+	// are who the game's deltas go to.
+	members  map[string]bool
+	paused   bool
+	seq      uint64
+	tick     uint64
+	turnMode bool
+	turn     int
+	orders   []CommandRequest
+	// started is when the first player joined, and ended when someone won.
+	started time.Time
+	ended   time.Time
+	// fielded are the players who have spawned a unit, and so can be eliminated.
+	fielded  map[string]bool
+	gameOver *GameOver
+	presence map[string]*Presence
+	// freezeDisconnected keeps the units of disconnected players out of play.
+	freezeDisconnected bool
+	mu                 *sync.Mutex
+}
+
+func NewWorld(id string, gameMap *Map, ruleset *Ruleset) *World {
+	return &World{
+		id:       id,
+		gameMap:  gameMap,
+		ruleset:  ruleset,
+		players:  map[string]*GameState{},
+		members:  map[string]bool{},
+		fielded:  map[string]bool{},
+		presence: map[string]*Presence{},
+		mu:       &sync.Mutex{},
+	}
+}
+
+func (w *World) ID() string {
+	return w.id
+}
+
+func (w *World) Info() GameInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return GameInfo{
+		ID:      w.id,
+		Players: len(w.members),
+		Paused:  w.paused,
+		Turn:    w.turn,
+		Over:    w.gameOver != nil,
+	}
+}
+
+func (w *World) SetPaused(paused bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = paused
+}
+
+// Join lets username into the game.
+func (w *World) Join(username string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.members[username] = true
+}
+
+// StartTurns switches the world to turn mode and returns the number of the first turn.
+func (w *World) StartTurns() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.turnMode = true
+	w.turn = 1
+	return w.turn
+}
+
+// player returns the state of username, creating it with the starting resources on the player's
+// first command. The game's clock starts with its first player.
+func (w *World) player(username string) *GameState {
+	gs, ok := w.players[username]
+	if !ok {
+		if w.started.IsZero() {
+			w.started = time.Now()
+		}
+		gs = NewGameState(username)
+		gs.GameID = w.id
+		gs.Map = w.gameMap
+		gs.Ruleset = w.ruleset
+		gs.Resources = w.ruleset.Economy().StartingResources
+		w.players[username] = gs
+	}
+	return gs
+}
+
+// HandleCommand returns the deltas to broadcast for req: its rejection, or the change it made
+// followed by the wars a real-time move starts.
+func (w *World) HandleCommand(req CommandRequest) []Broadcast {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delta, err := w.handleCommand(req)
+	if err != nil {
+		return []Broadcast{w.broadcast(StateDelta{
+			Kind:     DeltaKindRejected,
+			Username: req.Username,
+			Reason:   err.Error(),
+		})}
+	}
+	broadcasts := []Broadcast{w.broadcast(delta)}
+	if delta.Kind == DeltaKindMoved {
+		broadcasts = append(broadcasts, w.wars([]string{req.Username})...)
+	}
+	return broadcasts
+}
+
+func (w *World) handleCommand(req CommandRequest) (StateDelta, error) {
+	if req.Username == "" {
+		return StateDelta{}, errors.New("missing username")
+	}
+	if !w.members[req.Username] {
+		return StateDelta{}, fmt.Errorf("%s has not joined game %s", req.Username, w.id)
+	}
+	if req.Command == CommandKindSync {
+		w.player(req.Username)
+		delta := w.snapshot()
+		delta.Username = req.Username
+		return delta, nil
+	}
+	if w.gameOver != nil {
+		return StateDelta{}, errors.New("the game is over")
+	}
+	if w.paused {
+		return StateDelta{}, errors.New("the game is paused")
+	}
+
+	gs := w.player(req.Username)
+	switch req.Command {
+	case CommandKindSpawn:
+		unit, err := gs.spawnUnit(req.Location, req.Rank)
+		if err != nil {
+			return StateDelta{}, err
+		}
+		w.fielded[req.Username] = true
+		w.seq++
+		return StateDelta{
+			Seq:       w.seq,
+			Kind:      DeltaKindSpawned,
+			Username:  req.Username,
+			Units:     []Unit{unit},
+			Resources: map[string]int{req.Username: gs.GetResources()},
+		}, nil
+	case CommandKindMove:
+		path := req.Path
+		if len(path) == 0 && req.ToLocation != "" {
+			path = []Location{req.ToLocation}
+		}
+		if w.turnMode {
+			return w.queueOrder(gs, req, path)
+		}
+		units, err := gs.moveUnits(req.UnitIDs, path)
+		if err != nil {
+			return StateDelta{}, err
+		}
+		w.seq++
+		return StateDelta{
+			Seq:        w.seq,
+			Kind:       DeltaKindMoved,
+			Username:   req.Username,
+			Units:      units,
+			ToLocation: path[len(path)-1],
+		}, nil
+	default:
+		return StateDelta{}, fmt.Errorf("unknown command %q", req.Command)
+	}
+}
+
+// queueOrder checks a move against where the units are now and keeps it for the end of the turn. An
+// order replaces any earlier order for the same units.
+func (w *World) queueOrder(gs *GameState, req CommandRequest, path []Location) (StateDelta, error) {
+	units, err := gs.planMove(req.UnitIDs, path)
+	if err != nil {
+		return StateDelta{}, err
+	}
+	ordered := map[int]bool{}
+	for _, unit := range units {
+		ordered[unit.ID] = true
+	}
+	orders := w.orders[:0]
+	for _, order := range w.orders {
+		if order.Username == req.Username {
+			kept := []int{}
+			for _, id := range order.UnitIDs {
+				if !ordered[id] {
+					kept = append(kept, id)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			order.UnitIDs = kept
+		}
+		orders = append(orders, order)
+	}
+	req.Path = path
+	req.UnitIDs = nil
+	for _, unit := range units {
+		req.UnitIDs = append(req.UnitIDs, unit.ID)
+	}
+	w.orders = append(orders, req)
+
+	return StateDelta{
+		Kind:       DeltaKindQueued,
+		Username:   req.Username,
+		Units:      units,
+		ToLocation: path[len(path)-1],
+	}, nil
+}
+
+// EndTurn moves every unit that was given an order this turn, fights the wars the moves start and
+// starts the next turn. It returns the number of the turn that ended with the deltas to broadcast, or
+// false while the game is paused. Every order was checked against where the units stood when the
+// turn began and each unit has at most one, so the order they are carried out in makes no
+// difference.
+func (w *World) EndTurn() (int, []Broadcast, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.paused || w.gameOver != nil {
+		return 0, nil, false
+	}
+	broadcasts := []Broadcast{}
+	movers := []string{}
+	for _, order := range w.orders {
+		units, err := w.player(order.Username).moveUnits(order.UnitIDs, order.Path)
+		if err != nil {
+			broadcasts = append(broadcasts, w.broadcast(StateDelta{
+				Kind:     DeltaKindRejected,
+				Username: order.Username,
+				Reason:   err.Error(),
+			}))
+			continue
+		}
+		w.seq++
+		broadcasts = append(broadcasts, w.broadcast(StateDelta{
+			Seq:        w.seq,
+			Kind:       DeltaKindMoved,
+			Username:   order.Username,
+			Units:      units,
+			ToLocation: order.Path[len(order.Path)-1],
+			Turn:       w.turn,
+		}))
+		movers = append(movers, order.Username)
+	}
+	w.orders = nil
+	broadcasts = append(broadcasts, w.wars(movers)...)
+
+	ended := w.turn
+	w.turn++
+	return ended, broadcasts, true
+}
+
+// wars fights the wars started by the players who moved, in the order they moved. Whoever moved
+// first attacks, and each pair of players fights at most once.
+func (w *World) wars(movers []string) []Broadcast {
+	broadcasts := []Broadcast{}
+	usernames := make([]string, 0, len(w.players))
+	for username := range w.players {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	fought := map[[2]string]bool{}
+	for _, attacker := range movers {
+		for _, defender := range usernames {
+			pair := [2]string{min(attacker, defender), max(attacker, defender)}
+			if attacker == defender || fought[pair] {
+				continue
+			}
+			if delta, ok := w.war(w.players[attacker], w.players[defender]); ok {
+				fought[pair] = true
+				broadcasts = append(broadcasts, w.broadcast(delta))
+			}
+		}
+	}
+	return broadcasts
+}
+
+func (w *World) war(attacker, defender *GameState) (StateDelta, bool) {
+	if w.frozen(attacker.GetUsername()) || w.frozen(defender.GetUsername()) {
+		return StateDelta{}, false
+	}
+	results, killed, ok := resolveWar(w.ruleset, attacker.GetPlayerSnap(), defender.GetPlayerSnap(), rand.Int63)
+	if !ok {
+		return StateDelta{}, false
+	}
+	attacker.removeUnits(killed)
+	defender.removeUnits(killed)
+
+	w.seq++
+	return StateDelta{
+		Seq:      w.seq,
+		Kind:     DeltaKindWar,
+		Username: attacker.GetUsername(),
+		Units:    killed,
+		Wars:     results,
+	}, true
+}
+
+// Tick pays every player their income. It returns false while the game is paused or before anyone
+// has joined.
+func (w *World) Tick() (StateDelta, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.paused || w.gameOver != nil || len(w.players) == 0 {
+		return StateDelta{}, false
+	}
+	players := make([]Player, 0, len(w.players))
+	for _, gs := range w.players {
+		players = append(players, gs.GetPlayerSnap())
+	}
+	income := computeIncome(w.gameMap, w.ruleset, players)
+	for username, gs := range w.players {
+		if w.frozen(username) {
+			continue
+		}
+		gs.SetResources(gs.GetResources() + income[username])
+	}
+
+	w.seq++
+	w.tick++
+	return StateDelta{
+		Seq:       w.seq,
+		Kind:      DeltaKindIncome,
+		Resources: w.resources(),
+		Tick:      w.tick,
+	}, true
+}
+
+func (w *World) resources() map[string]int {
+	resources := map[string]int{}
+	for username, gs := range w.players {
+		resources[username] = gs.GetResources()
+	}
+	return resources
+}
+
+// snapshot lists every unit in the world, ordered by owner and ID, and every player's resources.
+func (w *World) snapshot() StateDelta {
+	units := []Unit{}
+	for _, gs := range w.players {
+		for _, unit := range gs.GetPlayerSnap().Units {
+			units = append(units, unit)
+		}
+	}
+	sortUnits(units)
+	return StateDelta{
+		Seq:       w.seq,
+		Kind:      DeltaKindSnapshot,
+		Units:     units,
+		Map:       w.gameMap,
+		Ruleset:   w.ruleset,
+		Resources: w.resources(),
+		Tick:      w.tick,
+		Turn:      w.turn,
+		GameOver:  w.gameOver,
+		Paused:    w.paused,
+	}
+}
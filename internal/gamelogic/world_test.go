@@ -0,0 +1,118 @@
+package gamelogic
+
+import "testing"
+
+func TestMoveStartsWar(t *testing.T) {
+	w := NewWorld("test", DefaultMap(), DefaultRuleset())
+	alice := spawn(t, w, "alice", "europe").Units[0]
+	spawn(t, w, "bob", "asia")
+	spawn(t, w, "carol", "antarctica")
+
+	broadcasts := w.HandleCommand(CommandRequest{Username: "alice", Command: CommandKindMove, UnitIDs: []int{alice.ID}, ToLocation: "asia"})
+	if len(broadcasts) != 2 || broadcasts[0].Delta.Kind != DeltaKindMoved || broadcasts[1].Delta.Kind != DeltaKindWar {
+		t.Fatalf("got %+v, expected the move and the war it starts", broadcasts)
+	}
+	moved, war := broadcasts[0].Delta, broadcasts[1].Delta
+	if war.Username != "alice" || len(war.Wars) != 1 || war.Wars[0].Defender != "bob" || war.Wars[0].Location != "asia" {
+		t.Errorf("got war %+v, expected alice to attack bob in asia", war.Wars)
+	}
+	if war.Seq != moved.Seq+1 {
+		t.Errorf("war has seq %d, expected it to follow the move's %d", war.Seq, moved.Seq)
+	}
+
+	// bob sees the move as it was made, before the war killed anyone.
+	bob := deliveryTo(t, broadcasts[0].Deliveries, "bob")
+	if len(bob.Units) != 1 || bob.Units[0].ID != alice.ID || bob.ToLocation != "asia" {
+		t.Errorf("got %v units moving to %q, expected bob to see alice's unit move to asia", bob.Units, bob.ToLocation)
+	}
+	if len(bob.Visible) != 1 || bob.Visible[0].ID != alice.ID {
+		t.Errorf("got visible %v, expected alice's unit in asia", bob.Visible)
+	}
+	// carol in antarctica can not see asia.
+	if carol := deliveryTo(t, broadcasts[0].Deliveries, "carol"); len(carol.Units) != 0 || carol.ToLocation != "" {
+		t.Errorf("carol saw %v units moving to %q", carol.Units, carol.ToLocation)
+	}
+	if bob := deliveryTo(t, broadcasts[1].Deliveries, "bob"); len(bob.Wars) != 1 {
+		t.Errorf("got wars %+v, expected bob to see the battle", bob.Wars)
+	}
+}
+
+func TestMoveWithoutWar(t *testing.T) {
+	w := NewWorld("test", DefaultMap(), DefaultRuleset())
+	alice := spawn(t, w, "alice", "europe").Units[0]
+	spawn(t, w, "bob", "australia")
+
+	broadcasts := w.HandleCommand(CommandRequest{Username: "alice", Command: CommandKindMove, UnitIDs: []int{alice.ID}, ToLocation: "asia"})
+	if len(broadcasts) != 1 || broadcasts[0].Delta.Kind != DeltaKindMoved {
+		t.Fatalf("got %+v, expected only the move", broadcasts)
+	}
+}
+
+func TestCommandsNeedMembership(t *testing.T) {
+	w := NewWorld("test", DefaultMap(), DefaultRuleset())
+	sync := CommandRequest{Username: "mallory", Command: CommandKindSync}
+	if delta := w.HandleCommand(sync)[0].Delta; delta.Kind != DeltaKindRejected {
+		t.Fatalf("got a %s delta for someone who never joined", delta.Kind)
+	}
+	if info := w.Info(); info.Players != 0 {
+		t.Errorf("got %d players, expected none", info.Players)
+	}
+
+	w.Join("alice")
+	if info := w.Info(); info.Players != 1 {
+		t.Errorf("got %d players, expected alice to count once they joined", info.Players)
+	}
+	// Members get every delta from the moment they join, before they send a command.
+	spawned := spawn(t, w, "bob", "asia")
+	if deliveries := w.Deliver(spawned); len(deliveries) != 2 || deliveries[0].Username != "alice" {
+		t.Errorf("got %+v, expected a delivery for alice and bob", deliveries)
+	}
+}
+
+func TestSnapshotCarriesPause(t *testing.T) {
+	w := NewWorld("test", DefaultMap(), DefaultRuleset())
+	w.Join("alice")
+	w.SetPaused(true)
+
+	// A client resumed from a save that was taken while the game ran.
+	gs := NewGameState("alice")
+	gs.SetGameID("test")
+	for _, b := range w.HandleCommand(CommandRequest{GameID: "test", Username: "alice", Command: CommandKindSync}) {
+		gs.HandleDelta(deliveryTo(t, b.Deliveries, "alice"))
+	}
+	if !gs.IsPaused() {
+		t.Error("the client does not know the game it joined is paused")
+	}
+}
+
+func TestClientsReplayTheServersWar(t *testing.T) {
+	w := NewWorld("test", DefaultMap(), DefaultRuleset())
+	clients := map[string]*GameState{"alice": NewGameState("alice"), "bob": NewGameState("bob")}
+	apply := func(broadcasts []Broadcast) {
+		t.Helper()
+		for _, b := range broadcasts {
+			for _, d := range b.Deliveries {
+				if b.Delta.Kind == DeltaKindWar && !clients[d.Username].checkWarResults(d.Delta.Wars, d.Delta.Units) {
+					t.Errorf("%s could not replay the war", d.Username)
+				}
+				clients[d.Username].HandleDelta(d.Delta)
+			}
+		}
+	}
+	w.Join("alice")
+	w.Join("bob")
+	apply(w.HandleCommand(CommandRequest{Username: "alice", Command: CommandKindSpawn, Location: "europe", Rank: RankInfantry}))
+	apply(w.HandleCommand(CommandRequest{Username: "alice", Command: CommandKindSpawn, Location: "europe", Rank: RankInfantry}))
+	apply(w.HandleCommand(CommandRequest{Username: "bob", Command: CommandKindSpawn, Location: "asia", Rank: RankInfantry}))
+
+	broadcasts := w.HandleCommand(CommandRequest{Username: "alice", Command: CommandKindMove, UnitIDs: []int{1, 2}, ToLocation: "asia"})
+	if len(broadcasts) != 2 || len(broadcasts[1].Delta.Units) == 0 {
+		t.Fatalf("got %+v, expected a war that kills someone", broadcasts)
+	}
+	apply(broadcasts)
+	for username, gs := range clients {
+		if got, want := len(gs.GetPlayerSnap().Units), len(w.players[username].GetPlayerSnap().Units); got != want {
+			t.Errorf("%s knows of %d unit(s) of their own, the server has %d", username, got, want)
+		}
+	}
+}
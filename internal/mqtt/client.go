@@ -0,0 +1,470 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultKeepAlive = 60 * time.Second
+	maxKeepAlive     = 65535 * time.Second
+)
+
+var ErrClosed = errors.New("mqtt connection is closed")
+
+type Options struct {
+	ClientID string
+	Username string
+	Password string
+	// CleanSession discards the session when the client disconnects. Without it the broker keeps
+	// the subscriptions and any unacknowledged QoS 1 messages until the client reconnects.
+	CleanSession bool
+	// KeepAlive is how long the connection may be idle, in whole seconds up to 65535. Zero means
+	// the default of a minute.
+	KeepAlive time.Duration
+}
+
+type Message struct {
+	Topic     string
+	Payload   []byte
+	QoS       QoS
+	Duplicate bool
+
+	packetID uint16
+	group    *ackGroup
+	acked    atomic.Bool
+}
+
+// ackGroup is shared by the copies of a message delivered to several subscriptions. The broker
+// only gets its PUBACK once every one of them has acknowledged its copy.
+type ackGroup struct {
+	remaining atomic.Int32
+}
+
+// Client is an MQTT 3.1.1 client connection supporting QoS 0 and 1. It is safe for concurrent use.
+type Client struct {
+	conn           net.Conn
+	writeMu        sync.Mutex
+	sessionPresent bool
+	cleanSession   bool
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan packet
+	subs    []*Subscription
+	err     error
+	done    chan struct{}
+}
+
+type Subscription struct {
+	Filter string
+	QoS    QoS
+	C      <-chan *Message
+
+	client *Client
+	ch     chan *Message
+	mu     sync.Mutex
+	// queue holds the messages C has not taken yet. It has no limit, so the read loop never waits
+	// for a slow handler, which may itself be waiting for a PUBACK only the read loop can read.
+	queue  []*Message
+	closed bool
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// deliver queues msg for the subscription and reports whether it was open.
+func (s *Subscription) deliver(msg *Message) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	s.queue = append(s.queue, msg)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// forward hands the queued messages to C in order until the subscription is closed.
+func (s *Subscription) forward() {
+	defer close(s.done)
+	defer close(s.ch)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.wake:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+		msg := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		select {
+		case s.ch <- msg:
+		case <-s.stop:
+			s.mu.Lock()
+			s.queue = append([]*Message{msg}, s.queue...)
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// close ends the subscription channel. The messages it never handed out are acknowledged so they do
+// not hold up the session, from a goroutine of their own as the client lock may be held.
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		close(s.stop)
+		<-s.done
+
+		s.mu.Lock()
+		dropped := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+		if len(dropped) > 0 {
+			go func() {
+				for _, msg := range dropped {
+					s.client.Ack(msg)
+				}
+			}()
+		}
+	})
+}
+
+// Dial connects to an MQTT broker such as RabbitMQ with the rabbitmq_mqtt plugin, which listens
+// on port 1883 by default.
+func Dial(addr string, opts Options) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Connect(conn, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Connect performs the MQTT handshake over an established connection.
+func Connect(conn net.Conn, opts Options) (*Client, error) {
+	if opts.ClientID == "" && !opts.CleanSession {
+		return nil, errors.New("a persistent session requires a client ID")
+	}
+	keepAlive := opts.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = defaultKeepAlive
+	}
+	if keepAlive < time.Second || keepAlive > maxKeepAlive || keepAlive%time.Second != 0 {
+		return nil, fmt.Errorf("keep alive must be a whole number of seconds between 1 and 65535, got %s", opts.KeepAlive)
+	}
+
+	err := writePacket(conn, encodeConnect(connectOptions{
+		clientID:     opts.ClientID,
+		username:     opts.Username,
+		password:     opts.Password,
+		cleanSession: opts.CleanSession,
+		keepAlive:    uint16(keepAlive / time.Second),
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("could not send CONNECT: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	p, err := readPacket(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CONNACK: %w", err)
+	}
+	sessionPresent, err := decodeConnack(p)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:           conn,
+		sessionPresent: sessionPresent,
+		cleanSession:   opts.CleanSession,
+		pending:        map[uint16]chan packet{},
+		done:           make(chan struct{}),
+	}
+	go c.readLoop(r)
+	go c.keepAlive(keepAlive / 2)
+	return c, nil
+}
+
+// SessionPresent reports whether the broker resumed a session left by an earlier connection.
+func (c *Client) SessionPresent() bool {
+	return c.sessionPresent
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		switch p.kind {
+		case packetPublish:
+			pub, err := decodePublish(p)
+			if err != nil {
+				c.shutdown(err)
+				return
+			}
+			c.dispatch(&Message{
+				Topic:     pub.topic,
+				Payload:   pub.payload,
+				QoS:       pub.qos,
+				Duplicate: pub.dup,
+				packetID:  pub.packetID,
+			})
+		case packetPuback, packetSuback, packetUnsuback:
+			id, err := decodePacketID(p)
+			if err != nil {
+				c.shutdown(err)
+				return
+			}
+			c.mu.Lock()
+			waiter, ok := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ok {
+				waiter <- p
+			}
+		case packetPingresp:
+		default:
+			c.shutdown(fmt.Errorf("unexpected packet type %d", p.kind))
+			return
+		}
+	}
+}
+
+// dispatch hands msg to every subscription whose filter matches its topic, as the broker sends a
+// message only once however many of the client's subscriptions it matches.
+func (c *Client) dispatch(msg *Message) {
+	c.mu.Lock()
+	var targets []*Subscription
+	for _, sub := range c.subs {
+		if MatchTopic(sub.Filter, msg.Topic) {
+			targets = append(targets, sub)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(targets) == 0 {
+		// A resumed session can deliver messages before they have been subscribed to again in
+		// this connection; acknowledge them so they do not hold up the session.
+		c.Ack(msg)
+		return
+	}
+	group := &ackGroup{}
+	group.remaining.Store(int32(len(targets)))
+	for _, sub := range targets {
+		delivery := &Message{
+			Topic:     msg.Topic,
+			Payload:   msg.Payload,
+			QoS:       msg.QoS,
+			Duplicate: msg.Duplicate,
+			packetID:  msg.packetID,
+			group:     group,
+		}
+		if !sub.deliver(delivery) {
+			c.Ack(delivery)
+		}
+	}
+}
+
+func (c *Client) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.write(packet{kind: packetPingreq}); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// shutdown records why the connection ended and releases everyone waiting on it.
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	for _, sub := range c.subs {
+		sub.close()
+	}
+	c.subs = nil
+	c.conn.Close()
+}
+
+// Err returns why the connection was closed, or nil while it is open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) write(p packet) error {
+	if err := c.Err(); err != nil {
+		return ErrClosed
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writePacket(c.conn, p)
+}
+
+// request sends a packet built for a fresh packet identifier and waits for the matching reply.
+func (c *Client) request(build func(packetID uint16) packet) (packet, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return packet{}, ErrClosed
+	}
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	id := c.nextID
+	reply := make(chan packet, 1)
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	if err := c.write(build(id)); err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return packet{}, err
+	}
+	select {
+	case p := <-reply:
+		return p, nil
+	case <-c.done:
+		return packet{}, c.Err()
+	}
+}
+
+// Publish sends payload to topic. With QoS 1 it waits for the broker to acknowledge the message.
+func (c *Client) Publish(topic string, payload []byte, qos QoS) error {
+	if qos == QoS0 {
+		return c.write(encodePublish(publishPacket{topic: topic, payload: payload}))
+	}
+	_, err := c.request(func(id uint16) packet {
+		return encodePublish(publishPacket{topic: topic, qos: qos, packetID: id, payload: payload})
+	})
+	return err
+}
+
+func (c *Client) Subscribe(filter string, qos QoS) (*Subscription, error) {
+	sub := &Subscription{
+		Filter: filter,
+		QoS:    qos,
+		client: c,
+		ch:     make(chan *Message),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	sub.C = sub.ch
+	go sub.forward()
+
+	c.mu.Lock()
+	c.subs = append(c.subs, sub)
+	c.mu.Unlock()
+
+	p, err := c.request(func(id uint16) packet {
+		return encodeSubscribe(id, filter, qos)
+	})
+	if err == nil && (len(p.body) < 3 || p.body[2] == 0x80) {
+		err = errors.New("subscription refused by broker")
+	}
+	if err != nil {
+		c.removeSubscription(sub)
+		return nil, fmt.Errorf("could not subscribe to %s: %w", filter, err)
+	}
+	return sub, nil
+}
+
+func (c *Client) removeSubscription(sub *Subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, s := range c.subs {
+		if s == sub {
+			c.subs = append(c.subs[:i], c.subs[i+1:]...)
+			break
+		}
+	}
+	sub.close()
+}
+
+func (s *Subscription) Unsubscribe() error {
+	defer s.client.removeSubscription(s)
+	_, err := s.client.request(func(id uint16) packet {
+		return encodeUnsubscribe(id, s.Filter)
+	})
+	return err
+}
+
+// Ack acknowledges a QoS 1 message. Until it is acknowledged the broker redelivers the message
+// whenever the session is resumed. A message that matched several subscriptions is acknowledged
+// once each of them has acknowledged it.
+func (c *Client) Ack(msg *Message) error {
+	if msg.QoS == QoS0 || !msg.acked.CompareAndSwap(false, true) {
+		return nil
+	}
+	if msg.group != nil && msg.group.remaining.Add(-1) > 0 {
+		return nil
+	}
+	return c.write(encodePacketID(packetPuback, 0, msg.packetID))
+}
+
+func (c *Client) Disconnect() error {
+	err := c.write(packet{kind: packetDisconnect})
+	c.shutdown(ErrClosed)
+	return err
+}
+
+// MatchTopic reports whether topic matches filter, which may contain + and # wildcards.
+func MatchTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i == len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,254 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroker stands in for an MQTT broker. It accepts the guest user, grants every subscription,
+// acknowledges QoS 1 publishes and sends each published message once to the client if any of its
+// filters match. Every packet it reads is kept in packets for the test to look at.
+type fakeBroker struct {
+	conn    net.Conn
+	packets chan packet
+
+	writeMu sync.Mutex
+	filters []string
+	nextID  uint16
+}
+
+func newFakeBroker(t *testing.T) (*fakeBroker, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	b := &fakeBroker{conn: server, packets: make(chan packet, 100)}
+	t.Cleanup(func() { server.Close() })
+	return b, client
+}
+
+func (b *fakeBroker) serve() {
+	r := bufio.NewReader(b.conn)
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		b.packets <- p
+		switch p.kind {
+		case packetConnect:
+			code := byte(4)
+			if username, ok := connectUsername(p); ok && username == "guest" {
+				code = 0
+			}
+			b.send(packet{kind: packetConnack, body: []byte{0, code}})
+		case packetSubscribe:
+			filter, rest, _ := readString(p.body[2:])
+			b.filters = append(b.filters, filter)
+			b.send(packet{kind: packetSuback, body: []byte{p.body[0], p.body[1], rest[0]}})
+		case packetPublish:
+			pub, _ := decodePublish(p)
+			if pub.qos == QoS1 {
+				b.send(encodePacketID(packetPuback, 0, pub.packetID))
+			}
+			for _, filter := range b.filters {
+				if MatchTopic(filter, pub.topic) {
+					b.nextID++
+					go b.send(encodePublish(publishPacket{topic: pub.topic, qos: pub.qos, packetID: b.nextID, payload: pub.payload}))
+					break
+				}
+			}
+		case packetPingreq:
+			b.send(packet{kind: packetPingresp})
+		}
+	}
+}
+
+// connectUsername reads the user name of a CONNECT packet.
+func connectUsername(p packet) (string, bool) {
+	_, rest, err := readString(p.body)
+	if err != nil || len(rest) < 4 {
+		return "", false
+	}
+	flags := rest[1]
+	_, rest, err = readString(rest[4:])
+	if err != nil || flags&0x80 == 0 {
+		return "", false
+	}
+	username, _, err := readString(rest)
+	return username, err == nil
+}
+
+func (b *fakeBroker) send(p packet) {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	writePacket(b.conn, p)
+}
+
+// expect returns the next packet the broker read, which must be of the given kind.
+func (b *fakeBroker) expect(t *testing.T, kind byte) packet {
+	t.Helper()
+	select {
+	case p := <-b.packets:
+		if p.kind != kind {
+			t.Fatalf("broker got packet type %d, expected %d", p.kind, kind)
+		}
+		return p
+	case <-time.After(time.Second):
+		t.Fatalf("broker got no packet of type %d", kind)
+		return packet{}
+	}
+}
+
+// expectNone fails if the broker reads a packet in the next moment.
+func (b *fakeBroker) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case p := <-b.packets:
+		t.Fatalf("broker got unexpected packet type %d", p.kind)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func connect(t *testing.T, opts Options) (*fakeBroker, *Client) {
+	t.Helper()
+	b, conn := newFakeBroker(t)
+	go b.serve()
+	c, err := Connect(conn, opts)
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	b.expect(t, packetConnect)
+	return b, c
+}
+
+func TestConnect(t *testing.T) {
+	b, conn := newFakeBroker(t)
+	go b.serve()
+	if _, err := Connect(conn, Options{ClientID: "peril", Username: "mallory", CleanSession: true}); err == nil {
+		t.Fatal("connected with a bad user name")
+	}
+	b.expect(t, packetConnect)
+
+	b, conn = newFakeBroker(t)
+	go b.serve()
+	c, err := Connect(conn, Options{ClientID: "peril", Username: "guest", Password: "guest", KeepAlive: 30 * time.Second})
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	if c.SessionPresent() {
+		t.Error("the broker had no session to resume")
+	}
+	p := b.expect(t, packetConnect)
+	_, rest, _ := readString(p.body)
+	if flags := rest[1]; flags&0x02 != 0 {
+		t.Error("CONNECT asks for a clean session")
+	}
+	if keepAlive := binary.BigEndian.Uint16(rest[2:]); keepAlive != 30 {
+		t.Errorf("CONNECT has a keep alive of %ds, expected 30s", keepAlive)
+	}
+}
+
+func TestConnectRejectsBadKeepAlive(t *testing.T) {
+	for _, keepAlive := range []time.Duration{-time.Second, 500 * time.Millisecond, 1500 * time.Millisecond, maxKeepAlive + time.Second} {
+		_, client := net.Pipe()
+		if _, err := Connect(client, Options{ClientID: "peril", CleanSession: true, KeepAlive: keepAlive}); err == nil {
+			t.Errorf("connected with a keep alive of %s", keepAlive)
+		}
+		client.Close()
+	}
+}
+
+func TestDispatchToEverySubscription(t *testing.T) {
+	b, c := connect(t, Options{ClientID: "peril", Username: "guest", CleanSession: true})
+	wildcard, err := c.Subscribe("game/+", QoS1)
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	b.expect(t, packetSubscribe)
+	exact, err := c.Subscribe("game/main", QoS1)
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	b.expect(t, packetSubscribe)
+
+	if err := c.Publish("game/main", []byte("hello"), QoS1); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	b.expect(t, packetPublish)
+
+	var msgs []*Message
+	for _, sub := range []*Subscription{wildcard, exact} {
+		select {
+		case msg := <-sub.C:
+			if string(msg.Payload) != "hello" {
+				t.Errorf("%s got %q", sub.Filter, msg.Payload)
+			}
+			msgs = append(msgs, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("%s got no message", sub.Filter)
+		}
+	}
+
+	c.Ack(msgs[0])
+	c.Ack(msgs[0])
+	b.expectNone(t)
+	c.Ack(msgs[1])
+	puback := b.expect(t, packetPuback)
+	if id, _ := decodePacketID(puback); id != msgs[1].packetID {
+		t.Errorf("PUBACK for packet %d, expected %d", id, msgs[1].packetID)
+	}
+	c.Ack(msgs[1])
+	b.expectNone(t)
+}
+
+func TestFailedRequestForgetsPacketID(t *testing.T) {
+	b, c := connect(t, Options{ClientID: "peril", Username: "guest", CleanSession: true})
+	b.conn.Close()
+
+	if _, err := c.Subscribe("game/+", QoS1); err == nil {
+		t.Fatal("subscribed over a closed connection")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) != 0 {
+		t.Errorf("%d request(s) left behind by the failed write", len(c.pending))
+	}
+}
+
+func TestHandlerCanPublishWhileMessagesQueue(t *testing.T) {
+	_, c := connect(t, Options{ClientID: "peril", Username: "guest", CleanSession: true})
+	sub, err := c.Subscribe("game/main", QoS1)
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	const n = 30
+	for i := 0; i < n; i++ {
+		if err := c.Publish("game/main", []byte{byte(i)}, QoS0); err != nil {
+			t.Fatalf("could not publish: %v", err)
+		}
+	}
+
+	// Each reply waits for a PUBACK, which the read loop only reads if it is not stuck delivering.
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			msg := <-sub.C
+			if err := c.Publish("replies/main", msg.Payload, QoS1); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("could not publish a reply: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler's publish never got its PUBACK")
+	}
+}
@@ -0,0 +1,237 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types of MQTT 3.1.1.
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+type QoS byte
+
+const (
+	QoS0 QoS = 0
+	QoS1 QoS = 1
+)
+
+const maxRemainingLength = 268435455
+
+// packet is a raw control packet: the fixed header split into type and flags, and the rest.
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func writePacket(w io.Writer, p packet) error {
+	if len(p.body) > maxRemainingLength {
+		return errors.New("packet is too large")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(p.kind<<4 | p.flags)
+	length := len(p.body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		buf.WriteByte(b)
+		if length == 0 {
+			break
+		}
+	}
+	buf.Write(p.body)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	length := 0
+	multiplier := 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("truncated string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("truncated string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+type connectOptions struct {
+	clientID     string
+	username     string
+	password     string
+	cleanSession bool
+	keepAlive    uint16
+}
+
+func encodeConnect(o connectOptions) packet {
+	flags := byte(0)
+	if o.cleanSession {
+		flags |= 0x02
+	}
+	if o.username != "" {
+		flags |= 0x80
+	}
+	if o.password != "" {
+		flags |= 0x40
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, o.keepAlive)
+	body = appendString(body, o.clientID)
+	if o.username != "" {
+		body = appendString(body, o.username)
+	}
+	if o.password != "" {
+		body = appendString(body, o.password)
+	}
+	return packet{kind: packetConnect, body: body}
+}
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// decodeConnack returns whether the broker resumed a previous session.
+func decodeConnack(p packet) (bool, error) {
+	if p.kind != packetConnack || len(p.body) != 2 {
+		return false, fmt.Errorf("expected CONNACK, got packet type %d", p.kind)
+	}
+	if code := p.body[1]; code != 0 {
+		reason, ok := connackErrors[code]
+		if !ok {
+			reason = fmt.Sprintf("return code %d", code)
+		}
+		return false, fmt.Errorf("connection refused: %s", reason)
+	}
+	return p.body[0]&0x01 != 0, nil
+}
+
+type publishPacket struct {
+	topic    string
+	qos      QoS
+	dup      bool
+	retain   bool
+	packetID uint16
+	payload  []byte
+}
+
+func encodePublish(pub publishPacket) packet {
+	flags := byte(pub.qos) << 1
+	if pub.dup {
+		flags |= 0x08
+	}
+	if pub.retain {
+		flags |= 0x01
+	}
+	body := appendString(nil, pub.topic)
+	if pub.qos > QoS0 {
+		body = binary.BigEndian.AppendUint16(body, pub.packetID)
+	}
+	body = append(body, pub.payload...)
+	return packet{kind: packetPublish, flags: flags, body: body}
+}
+
+func decodePublish(p packet) (publishPacket, error) {
+	pub := publishPacket{
+		qos:    QoS(p.flags >> 1 & 0x03),
+		dup:    p.flags&0x08 != 0,
+		retain: p.flags&0x01 != 0,
+	}
+	if pub.qos > QoS1 {
+		return publishPacket{}, fmt.Errorf("unsupported QoS %d", pub.qos)
+	}
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return publishPacket{}, err
+	}
+	pub.topic = topic
+	if pub.qos > QoS0 {
+		if len(rest) < 2 {
+			return publishPacket{}, errors.New("truncated PUBLISH packet")
+		}
+		pub.packetID = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	pub.payload = rest
+	return pub, nil
+}
+
+func encodePacketID(kind byte, flags byte, packetID uint16) packet {
+	return packet{kind: kind, flags: flags, body: binary.BigEndian.AppendUint16(nil, packetID)}
+}
+
+func decodePacketID(p packet) (uint16, error) {
+	if len(p.body) < 2 {
+		return 0, fmt.Errorf("packet type %d is missing its packet identifier", p.kind)
+	}
+	return binary.BigEndian.Uint16(p.body), nil
+}
+
+func encodeSubscribe(packetID uint16, filter string, qos QoS) packet {
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	body = appendString(body, filter)
+	body = append(body, byte(qos))
+	return packet{kind: packetSubscribe, flags: 0x02, body: body}
+}
+
+func encodeUnsubscribe(packetID uint16, filter string) packet {
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	body = appendString(body, filter)
+	return packet{kind: packetUnsubscribe, flags: 0x02, body: body}
+}
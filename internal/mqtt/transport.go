@@ -0,0 +1,125 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// Transport adapts a Client to pubsub.Transport. RabbitMQ's MQTT plugin publishes to and binds
+// queues on a single topic exchange, set with mqtt.exchange in the broker config, so the
+// transport only serves that exchange. Set it to peril_topic: pause and turn changes come with the
+// game deltas there. MQTT has no priorities, so they arrive in order with the moves rather than
+// ahead of them.
+type Transport struct {
+	client   *Client
+	exchange string
+}
+
+func NewTransport(client *Client, exchange string) *Transport {
+	return &Transport{
+		client:   client,
+		exchange: exchange,
+	}
+}
+
+// TopicFromRoutingKey maps an AMQP routing key or binding pattern onto an MQTT topic or filter,
+// e.g. army_moves.* becomes army_moves/+.
+func TopicFromRoutingKey(key string) string {
+	levels := strings.Split(key, ".")
+	for i, level := range levels {
+		if level == "*" {
+			levels[i] = "+"
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// RoutingKeyFromTopic is the inverse of TopicFromRoutingKey.
+func RoutingKeyFromTopic(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		if level == "+" {
+			levels[i] = "*"
+		}
+	}
+	return strings.Join(levels, ".")
+}
+
+func (t *Transport) checkExchange(exchange string) error {
+	if exchange != t.exchange {
+		return fmt.Errorf("exchange %s is not reachable over MQTT, only %s is", exchange, t.exchange)
+	}
+	return nil
+}
+
+// Publish sends the message with QoS 1. MQTT 3.1.1 has no content type, so it is dropped, and no
+// expiration or priority, so messages asking for them are refused.
+func (t *Transport) Publish(exchange, key string, msg pubsub.Message) error {
+	if err := t.checkExchange(exchange); err != nil {
+		return err
+	}
+	if msg.Expiration > 0 || msg.Priority > 0 {
+		return errors.New("expiration and priority are not supported over MQTT")
+	}
+	return t.client.Publish(TopicFromRoutingKey(key), msg.Body, QoS1)
+}
+
+// Subscribe subscribes with QoS 1. The broker names the queue after the client ID, so queueName
+// is not used, and the queue is durable exactly when the session is persistent. The broker declares
+// the queue itself, so any queue argument beyond its type and dead letter exchange is refused.
+func (t *Transport) Subscribe(exchange, queueName, key string, simpleQueueType pubsub.SimpleQueueType, args map[string]any) (<-chan pubsub.Delivery, error) {
+	if err := t.checkExchange(exchange); err != nil {
+		return nil, err
+	}
+	for name := range args {
+		if name != "x-queue-type" && name != "x-dead-letter-exchange" {
+			return nil, fmt.Errorf("queue argument %s is not supported over MQTT", name)
+		}
+	}
+	switch simpleQueueType {
+	case pubsub.SimpleQueueTypeTransient:
+	case pubsub.SimpleQueueTypeDurable, pubsub.SimpleQueueTypeQuorum:
+		if t.client.cleanSession {
+			return nil, fmt.Errorf("durable subscriptions require a persistent MQTT session")
+		}
+	default:
+		return nil, fmt.Errorf("queue type %v is not supported over MQTT", simpleQueueType)
+	}
+
+	sub, err := t.client.Subscribe(TopicFromRoutingKey(key), QoS1)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan pubsub.Delivery)
+	go func() {
+		defer close(deliveries)
+		for msg := range sub.C {
+			msg := msg
+			deliveries <- pubsub.Delivery{
+				Message: pubsub.Message{
+					RoutingKey: RoutingKeyFromTopic(msg.Topic),
+					Body:       msg.Payload,
+				},
+				Ack: func() error { return t.client.Ack(msg) },
+				// MQTT has no negative acknowledgement. Discarding acknowledges the message, and
+				// requeueing leaves it unacknowledged so the broker delivers it again once the
+				// session is resumed.
+				Nack: func(requeue bool) error {
+					if requeue {
+						return nil
+					}
+					return t.client.Ack(msg)
+				},
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+func (t *Transport) Close() error {
+	return t.client.Disconnect()
+}
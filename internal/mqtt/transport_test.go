@@ -0,0 +1,78 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+type greeting struct {
+	From string
+	Text string
+}
+
+// TestTransportVerifiesSignature checks that signing works without headers, which MQTT 3.1.1 does
+// not have: the signature travels in the message body.
+func TestTransportVerifiesSignature(t *testing.T) {
+	b, c := connect(t, Options{ClientID: "peril", Username: "guest", CleanSession: true})
+	transport := NewTransport(c, "peril_topic")
+
+	alice, err := pubsub.LoadIdentity(t.TempDir(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := pubsub.NewKeyStore()
+	keys.Trust(alice.Public())
+
+	received := make(chan greeting, 2)
+	err = pubsub.SubscribeJSONTransport(transport, "peril_topic", "greetings", "greetings.*", pubsub.SimpleQueueTypeTransient,
+		func(g greeting) pubsub.AckType {
+			received <- g
+			return pubsub.Ack
+		},
+		pubsub.WithVerifier(keys, func(g greeting) string { return g.From }),
+	)
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	b.expect(t, packetSubscribe)
+
+	// The unsigned greeting is discarded, which acknowledges it over MQTT.
+	if err := pubsub.PublishJSONTransport(transport, "peril_topic", "greetings.bob", greeting{From: "alice", Text: "forged"}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	b.expect(t, packetPublish)
+	b.expect(t, packetPuback)
+
+	if err := pubsub.PublishJSONTransport(transport, "peril_topic", "greetings.bob", greeting{From: "alice", Text: "hi"}, pubsub.WithSigner(alice)); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	b.expect(t, packetPublish)
+	select {
+	case g := <-received:
+		if g.Text != "hi" {
+			t.Errorf("got %+v, expected only the signed greeting", g)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the signed greeting was not delivered")
+	}
+	b.expect(t, packetPuback)
+}
+
+func TestTransportRefusesUnsupportedOptions(t *testing.T) {
+	_, c := connect(t, Options{ClientID: "peril", Username: "guest", CleanSession: true})
+	transport := NewTransport(c, "peril_topic")
+
+	err := pubsub.SubscribeJSONTransport(transport, "peril_topic", "greetings", "greetings.*", pubsub.SimpleQueueTypeTransient,
+		func(g greeting) pubsub.AckType { return pubsub.Ack },
+		pubsub.WithMaxLength(10),
+	)
+	if err == nil {
+		t.Error("subscribed with a max length MQTT can not declare")
+	}
+	err = pubsub.PublishJSONTransport(transport, "peril_topic", "greetings.bob", greeting{}, pubsub.WithExpiration(time.Second))
+	if err == nil {
+		t.Error("published with an expiration MQTT can not carry")
+	}
+}
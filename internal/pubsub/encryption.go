@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Messages are encrypted for a single recipient: a fresh X25519 key is agreed with the recipient's
+// encryption key, and the shared secret keys AES-256-GCM through HKDF-SHA256. Nobody but the
+// recipient can read the message, including the other subscribers of the exchange.
+const encryptionInfo = "peril message encryption v1"
+
+var errNotRecipient = errors.New("message is encrypted for somebody else")
+
+// WithEncryption encrypts the message for recipient, whose key has to be in ks. Signing, if any,
+// covers the encrypted body.
+func WithEncryption(ks *KeyStore, recipient string) PublishOption {
+	return func(o *publishOptions) {
+		o.recipients = ks
+		o.recipient = recipient
+	}
+}
+
+// WithDecryption decrypts the messages encrypted for id. Messages encrypted for anyone else are
+// acknowledged and skipped.
+func WithDecryption(id *Identity) QueueOption {
+	return func(o *queueOptions) {
+		o.identity = id
+	}
+}
+
+// encryptFor seals body for recipient. The ephemeral public key and the nonce are prefixed to the
+// ciphertext, and the recipient's name and the routing key are authenticated with it.
+func encryptFor(recipient PublicIdentity, routingKey string, body []byte) ([]byte, error) {
+	recipientKey, err := ecdh.X25519().NewPublicKey(recipient.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key for %s", recipient.Name)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate encryption key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipientKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not agree on a key: %w", err)
+	}
+	aead, err := messageAEAD(shared, ephemeral.PublicKey().Bytes(), recipient.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := append([]byte{}, ephemeral.PublicKey().Bytes()...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, body, additionalData(recipient.Name, routingKey)), nil
+}
+
+func (id *Identity) decrypt(routingKey string, sealed []byte) ([]byte, error) {
+	const keySize = 32
+	if len(sealed) < keySize {
+		return nil, errors.New("encrypted message is too short")
+	}
+	ephemeralKey, err := ecdh.X25519().NewPublicKey(sealed[:keySize])
+	if err != nil {
+		return nil, errors.New("encrypted message has an invalid key")
+	}
+	shared, err := id.encryptionKey.ECDH(ephemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not agree on a key: %w", err)
+	}
+	aead, err := messageAEAD(shared, sealed[:keySize], id.encryptionKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[keySize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted message is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	body, err := aead.Open(nil, nonce, ciphertext, additionalData(id.name, routingKey))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt message: %w", err)
+	}
+	return body, nil
+}
+
+func additionalData(recipient, routingKey string) []byte {
+	return []byte(recipient + "\x00" + routingKey)
+}
+
+// messageAEAD derives the AES-256-GCM key of one message with HKDF-SHA256, salted with both public
+// keys.
+func messageAEAD(shared, ephemeralKey, recipientKey []byte) (cipher.AEAD, error) {
+	extract := hmac.New(sha256.New, append(append([]byte{}, ephemeralKey...), recipientKey...))
+	extract.Write(shared)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(encryptionInfo))
+	expand.Write([]byte{1})
+	block, err := aes.NewCipher(expand.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AEAD: %w", err)
+	}
+	return aead, nil
+}
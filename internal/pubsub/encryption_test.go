@@ -0,0 +1,53 @@
+package pubsub
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptForRecipient(t *testing.T) {
+	dir := t.TempDir()
+	server, err := LoadIdentity(dir, "peril_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := LoadIdentity(dir, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := LoadIdentity(dir, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := NewKeyStore()
+	if err := ks.Trust(server.Public()); err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"Command":"move"}`)
+	sealed, err := newPublishOptions([]PublishOption{WithSigner(alice), WithEncryption(ks, "peril_server")}).wrap("game.main.game_commands.alice", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("move")) {
+		t.Fatal("the encrypted message contains the plain body")
+	}
+
+	got, _, err := newQueueOptions([]QueueOption{WithDecryption(server)}).open("game.main.game_commands.alice", sealed)
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("open = %q, %v, want the plain body", got, err)
+	}
+	if _, _, err := newQueueOptions([]QueueOption{WithDecryption(bob)}).open("game.main.game_commands.alice", sealed); !errors.Is(err, errNotRecipient) {
+		t.Errorf("another player opening the message got %v, want errNotRecipient", err)
+	}
+	if _, _, err := newQueueOptions(nil).open("game.main.game_commands.alice", sealed); !errors.Is(err, errNotRecipient) {
+		t.Errorf("a subscriber without a key got %v, want errNotRecipient", err)
+	}
+	if _, _, err := newQueueOptions([]QueueOption{WithDecryption(server)}).open("game.main.game_commands.bob", sealed); err == nil {
+		t.Error("a message moved to another routing key was decrypted")
+	}
+	if _, err := newPublishOptions([]PublishOption{WithEncryption(ks, "carol")}).wrap("k", "application/json", body); err == nil {
+		t.Error("a message was encrypted for a recipient without a key")
+	}
+}
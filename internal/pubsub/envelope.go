@@ -0,0 +1,96 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// envelopeMagic starts the body of every signed or encrypted message. Neither JSON nor GOB bodies start with a
+// NUL byte, so a subscriber can tell an envelope from a plain body.
+const envelopeMagic = "\x00peril1\x00"
+
+const envelopeContentType = "application/vnd.peril.envelope+json"
+
+// envelope is the body of a signed or encrypted message. Everything needed to check and open the
+// message travels in the body rather than in headers, so that it survives transports that drop
+// headers. A signed envelope carries the signer's encryption key too, so they can be written to.
+type envelope struct {
+	Signer        string `json:"signer,omitempty"`
+	SigningKey    []byte `json:"signing_key,omitempty"`
+	EncryptionKey []byte `json:"encryption_key,omitempty"`
+	Signature     []byte `json:"signature,omitempty"`
+	Recipient     string `json:"recipient,omitempty"`
+	ContentType   string `json:"content_type"`
+	Payload       []byte `json:"payload"`
+}
+
+func (env envelope) marshal() ([]byte, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode envelope: %w", err)
+	}
+	return append([]byte(envelopeMagic), data...), nil
+}
+
+// parseEnvelope returns the envelope body is wrapped in, or false if body is a plain message.
+func parseEnvelope(body []byte) (envelope, bool, error) {
+	if !bytes.HasPrefix(body, []byte(envelopeMagic)) {
+		return envelope{}, false, nil
+	}
+	var env envelope
+	if err := json.Unmarshal(body[len(envelopeMagic):], &env); err != nil {
+		return envelope{}, true, fmt.Errorf("invalid envelope: %w", err)
+	}
+	return env, true, nil
+}
+
+// signedData is what the signature of env covers. The routing key is part of it, so a signed message
+// can not be replayed to another queue.
+func (env envelope) signedData(routingKey string) []byte {
+	var buf bytes.Buffer
+	for _, field := range [][]byte{
+		[]byte(envelopeMagic),
+		[]byte(routingKey),
+		[]byte(env.Signer),
+		env.SigningKey,
+		env.EncryptionKey,
+		[]byte(env.Recipient),
+		[]byte(env.ContentType),
+		env.Payload,
+	} {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	return buf.Bytes()
+}
+
+// wrap puts body in an envelope, encrypting it for the recipient and signing it as asked.
+func (o publishOptions) wrap(routingKey, contentType string, body []byte) ([]byte, error) {
+	env := envelope{ContentType: contentType, Payload: body}
+	if o.recipient != "" {
+		recipient, ok := o.recipients.Key(o.recipient)
+		if !ok {
+			return nil, fmt.Errorf("no encryption key for %s", o.recipient)
+		}
+		sealed, err := encryptFor(recipient, routingKey, body)
+		if err != nil {
+			return nil, err
+		}
+		env.Recipient = o.recipient
+		env.Payload = sealed
+	}
+	if o.identity != nil {
+		pub := o.identity.Public()
+		env.Signer = pub.Name
+		env.SigningKey = pub.SigningKey
+		env.EncryptionKey = pub.EncryptionKey
+		env.Signature = ed25519.Sign(o.identity.signingKey, env.signedData(routingKey))
+	}
+	return env.marshal()
+}
+
+var errNotSigned = errors.New("message is not signed")
@@ -2,14 +2,13 @@ package pubsub
 
 import (
 	"bytes"
-	"context"
 	"encoding/gob"
 	"fmt"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func PublishGOB[T any](ch *amqp.Channel, exchange, key string, val T) error {
+func PublishGOB[T any](ch *amqp.Channel, exchange, key string, val T, opts ...PublishOption) error {
 	// Encode the value with GOB
 	var buffer bytes.Buffer
 	encoder := gob.NewEncoder(&buffer)
@@ -19,15 +18,7 @@ func PublishGOB[T any](ch *amqp.Channel, exchange, key string, val T) error {
 	}
 	body := buffer.Bytes()
 	// Publish the message
-	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/gob",
-		Body:        body,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
-	}
-
-	return nil
+	return publish(ch, exchange, key, "application/gob", body, opts)
 }
 
 func SubscribeGOB[T any](
@@ -35,8 +26,9 @@ func SubscribeGOB[T any](
 	exchange,
 	queueName,
 	key string,
-	simpleQueueType SimpleQueueType, // an enum to represent "durable" or "transient"
+	simpleQueueType SimpleQueueType, // an enum to represent "durable", "transient", "quorum" or "stream"
 	handler func(T) AckType,
+	opts ...QueueOption,
 ) error {
 	unmarshaller := func(data []byte) (T, error) {
 		buffer := bytes.NewBuffer(data)
@@ -48,5 +40,5 @@ func SubscribeGOB[T any](
 		return val, nil
 	}
 
-	return subscribe(conn, exchange, queueName, key, simpleQueueType, handler, unmarshaller)
+	return subscribe(conn, exchange, queueName, key, simpleQueueType, handler, unmarshaller, opts...)
 }
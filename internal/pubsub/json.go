@@ -1,14 +1,13 @@
 package pubsub
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
+func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T, opts ...PublishOption) error {
 	// Marshal the value to JSON
 	body, err := json.Marshal(val)
 	if err != nil {
@@ -16,15 +15,7 @@ func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
 	}
 
 	// Publish the message
-	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        body,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
-	}
-
-	return nil
+	return publish(ch, exchange, key, "application/json", body, opts)
 }
 
 func SubscribeJSON[T any](
@@ -32,8 +23,9 @@ func SubscribeJSON[T any](
 	exchange,
 	queueName,
 	key string,
-	simpleQueueType SimpleQueueType, // an enum to represent "durable" or "transient"
+	simpleQueueType SimpleQueueType, // an enum to represent "durable", "transient", "quorum" or "stream"
 	handler func(T) AckType,
+	opts ...QueueOption,
 ) error {
 	unmarshaller := func(data []byte) (T, error) {
 		var val T
@@ -43,5 +35,5 @@ func SubscribeJSON[T any](
 		return val, nil
 	}
 
-	return subscribe(conn, exchange, queueName, key, simpleQueueType, handler, unmarshaller)
+	return subscribe(conn, exchange, queueName, key, simpleQueueType, handler, unmarshaller, opts...)
 }
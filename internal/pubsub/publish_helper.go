@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Message priorities, kept within the 0-9 range AMQP brokers are expected to support.
+const (
+	PriorityNormal uint8 = 0
+	PriorityHigh   uint8 = 9
+)
+
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	expiration time.Duration
+	priority   uint8
+	identity   *Identity
+	recipients *KeyStore
+	recipient  string
+}
+
+// WithExpiration drops the message if it has not been consumed within ttl.
+func WithExpiration(ttl time.Duration) PublishOption {
+	return func(o *publishOptions) {
+		o.expiration = ttl
+	}
+}
+
+// WithPriority publishes the message with the given priority. It only has an effect on queues
+// declared with WithMaxPriority, and only orders the messages waiting in the same queue.
+func WithPriority(priority uint8) PublishOption {
+	return func(o *publishOptions) {
+		o.priority = priority
+	}
+}
+
+func newPublishOptions(opts []PublishOption) publishOptions {
+	var o publishOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o publishOptions) validate() error {
+	if o.expiration < 0 {
+		return errors.New("expiration can not be negative")
+	}
+	if o.expiration%time.Millisecond != 0 {
+		return errors.New("expiration must be a whole number of milliseconds")
+	}
+	if o.priority > PriorityHigh {
+		return fmt.Errorf("priority must be between %d and %d", PriorityNormal, PriorityHigh)
+	}
+	return nil
+}
+
+// prepare checks the options and signs or encrypts body as asked, returning what to publish.
+func (o publishOptions) prepare(key, contentType string, body []byte) (Message, error) {
+	if err := o.validate(); err != nil {
+		return Message{}, fmt.Errorf("invalid publish options: %w", err)
+	}
+	msg := Message{
+		RoutingKey:  key,
+		ContentType: contentType,
+		Body:        body,
+		Expiration:  o.expiration,
+		Priority:    o.priority,
+	}
+	if o.identity != nil || o.recipient != "" {
+		wrapped, err := o.wrap(key, contentType, body)
+		if err != nil {
+			return Message{}, fmt.Errorf("failed to sign or encrypt message: %w", err)
+		}
+		msg.Body = wrapped
+		msg.ContentType = envelopeContentType
+	}
+	return msg, nil
+}
+
+func publish(ch *amqp.Channel, exchange, key, contentType string, body []byte, opts []PublishOption) error {
+	prepared, err := newPublishOptions(opts).prepare(key, contentType, body)
+	if err != nil {
+		return err
+	}
+
+	msg := amqp.Publishing{
+		ContentType: prepared.ContentType,
+		Body:        prepared.Body,
+		Priority:    prepared.Priority,
+	}
+	if prepared.Expiration > 0 {
+		msg.Expiration = strconv.FormatInt(prepared.Expiration.Milliseconds(), 10)
+	}
+
+	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, msg)
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package pubsub
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -9,15 +13,277 @@ type SimpleQueueType int
 const (
 	SimpleQueueTypeDurable SimpleQueueType = iota + 1
 	SimpleQueueTypeTransient
+	SimpleQueueTypeQuorum
+	SimpleQueueTypeStream
+	// SimpleQueueTypeTransientStream is a stream that forgets its messages after a while. Streams
+	// can not be exclusive or auto-delete, so it is declared durable like any other stream.
+	SimpleQueueTypeTransientStream
+)
+
+// DeadLetterExchange receives the messages queues reject or expire. Streams can not dead-letter,
+// so subscribers to a stream republish the messages they reject to it themselves.
+const DeadLetterExchange = "peril_dlx"
+
+// transientStreamMaxAge is how long a transient stream keeps its messages unless WithMessageTTL
+// says otherwise.
+const transientStreamMaxAge = time.Hour
+
+// Stream offsets understood by RabbitMQ in addition to a numeric offset or a timestamp.
+const (
+	StreamOffsetFirst = "first"
+	StreamOffsetLast  = "last"
+	StreamOffsetNext  = "next"
+)
+
+// Overflow policies for queues with a length limit.
+type Overflow string
+
+const (
+	OverflowDropHead         Overflow = "drop-head"
+	OverflowRejectPublish    Overflow = "reject-publish"
+	OverflowRejectPublishDLX Overflow = "reject-publish-dlx"
 )
 
+type QueueOption func(*queueOptions)
+
+type queueOptions struct {
+	deliveryLimit  int
+	streamOffset   any
+	messageTTL     time.Duration
+	maxLength      int
+	maxLengthBytes int64
+	overflow       Overflow
+	maxPriority    uint8
+	limiter        *RateLimiter
+	limitKey       func(msg Message, val any) string
+	keyStore       *KeyStore
+	claimedSigner  func(val any) string
+	identity       *Identity
+}
+
+// WithDeliveryLimit caps how many times a quorum queue redelivers a message before dead-lettering it.
+func WithDeliveryLimit(limit int) QueueOption {
+	return func(o *queueOptions) {
+		o.deliveryLimit = limit
+	}
+}
+
+// WithStreamOffset selects where a stream consumer starts reading. The offset can be one of the
+// StreamOffset* names, an absolute int64 offset or a time.Time.
+func WithStreamOffset(offset any) QueueOption {
+	return func(o *queueOptions) {
+		o.streamOffset = offset
+	}
+}
+
+// WithMessageTTL expires messages that have waited in the queue for longer than ttl. Streams keep
+// their messages for ttl, which must be a whole number of seconds.
+func WithMessageTTL(ttl time.Duration) QueueOption {
+	return func(o *queueOptions) {
+		o.messageTTL = ttl
+	}
+}
+
+// WithMaxLength limits the number of ready messages the queue holds.
+func WithMaxLength(length int) QueueOption {
+	return func(o *queueOptions) {
+		o.maxLength = length
+	}
+}
+
+// WithMaxLengthBytes limits the total body size of ready messages the queue holds.
+func WithMaxLengthBytes(length int64) QueueOption {
+	return func(o *queueOptions) {
+		o.maxLengthBytes = length
+	}
+}
+
+// WithOverflow sets what happens once the queue reaches its length limit.
+func WithOverflow(overflow Overflow) QueueOption {
+	return func(o *queueOptions) {
+		o.overflow = overflow
+	}
+}
+
+// WithMaxPriority turns a classic queue into a priority queue supporting priorities up to max.
+// Quorum queues only know priorities from RabbitMQ 4.0 on, and the broker in the Dockerfile is
+// 3.13, so they refuse it.
+func WithMaxPriority(max uint8) QueueOption {
+	return func(o *queueOptions) {
+		o.maxPriority = max
+	}
+}
+
+func newQueueOptions(opts []QueueOption) queueOptions {
+	var o queueOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o queueOptions) validate(simpleQueueType SimpleQueueType) error {
+	switch simpleQueueType {
+	case SimpleQueueTypeDurable, SimpleQueueTypeTransient, SimpleQueueTypeQuorum, SimpleQueueTypeStream, SimpleQueueTypeTransientStream:
+	default:
+		return fmt.Errorf("unknown queue type: %v", simpleQueueType)
+	}
+
+	if o.deliveryLimit < 0 {
+		return errors.New("delivery limit can not be negative")
+	}
+	if o.deliveryLimit > 0 && simpleQueueType != SimpleQueueTypeQuorum {
+		return errors.New("delivery limit is only supported by quorum queues")
+	}
+
+	if o.streamOffset != nil {
+		if !simpleQueueType.isStream() {
+			return errors.New("stream offset is only supported by stream queues")
+		}
+		switch offset := o.streamOffset.(type) {
+		case string:
+			if offset != StreamOffsetFirst && offset != StreamOffsetLast && offset != StreamOffsetNext {
+				return fmt.Errorf("invalid stream offset: %q", offset)
+			}
+		case int:
+			if offset < 0 {
+				return errors.New("stream offset can not be negative")
+			}
+		case int64:
+			if offset < 0 {
+				return errors.New("stream offset can not be negative")
+			}
+		case time.Time:
+		default:
+			return fmt.Errorf("unsupported stream offset type: %T", offset)
+		}
+	}
+
+	if o.messageTTL < 0 {
+		return errors.New("message TTL can not be negative")
+	}
+	if o.messageTTL > 0 {
+		if simpleQueueType.isStream() && o.messageTTL%time.Second != 0 {
+			return errors.New("stream message TTL must be a whole number of seconds")
+		}
+		if o.messageTTL%time.Millisecond != 0 {
+			return errors.New("message TTL must be a whole number of milliseconds")
+		}
+	}
+
+	if o.maxLength < 0 {
+		return errors.New("max length can not be negative")
+	}
+	if o.maxLength > 0 && simpleQueueType.isStream() {
+		return errors.New("max length is not supported by stream queues")
+	}
+	if o.maxLengthBytes < 0 {
+		return errors.New("max length bytes can not be negative")
+	}
+
+	if o.overflow != "" {
+		switch o.overflow {
+		case OverflowDropHead, OverflowRejectPublish, OverflowRejectPublishDLX:
+		default:
+			return fmt.Errorf("invalid overflow policy: %q", o.overflow)
+		}
+		if simpleQueueType.isStream() {
+			return errors.New("overflow policy is not supported by stream queues")
+		}
+		if o.overflow == OverflowRejectPublishDLX && simpleQueueType == SimpleQueueTypeQuorum {
+			return errors.New("reject-publish-dlx is not supported by quorum queues")
+		}
+		if o.maxLength == 0 && o.maxLengthBytes == 0 {
+			return errors.New("overflow policy requires a max length or max length bytes")
+		}
+	}
+
+	if o.maxPriority > 0 && simpleQueueType.isStream() {
+		return errors.New("max priority is not supported by stream queues")
+	}
+	if o.maxPriority > 0 && simpleQueueType == SimpleQueueTypeQuorum {
+		return errors.New("max priority is not supported by quorum queues before RabbitMQ 4.0")
+	}
+
+	if o.limiter != nil {
+		if o.limiter.rate <= 0 {
+			return errors.New("rate limit must be positive")
+		}
+		if o.limiter.burst < 1 {
+			return errors.New("rate limit burst must be at least 1")
+		}
+	}
+	return nil
+}
+
+func (t SimpleQueueType) isStream() bool {
+	return t == SimpleQueueTypeStream || t == SimpleQueueTypeTransientStream
+}
+
+func (o queueOptions) declareArgs(simpleQueueType SimpleQueueType) amqp.Table {
+	args := amqp.Table{}
+	// Streams do not take a dead letter exchange, their subscribers dead-letter instead.
+	if !simpleQueueType.isStream() {
+		args["x-dead-letter-exchange"] = DeadLetterExchange
+	}
+	switch simpleQueueType {
+	case SimpleQueueTypeQuorum:
+		args["x-queue-type"] = "quorum"
+		if o.deliveryLimit > 0 {
+			args["x-delivery-limit"] = o.deliveryLimit
+		}
+	case SimpleQueueTypeStream, SimpleQueueTypeTransientStream:
+		args["x-queue-type"] = "stream"
+	}
+	maxAge := o.messageTTL
+	if maxAge == 0 && simpleQueueType == SimpleQueueTypeTransientStream {
+		maxAge = transientStreamMaxAge
+	}
+	switch {
+	case simpleQueueType.isStream() && maxAge > 0:
+		args["x-max-age"] = fmt.Sprintf("%ds", int64(maxAge/time.Second))
+	case o.messageTTL > 0:
+		args["x-message-ttl"] = o.messageTTL.Milliseconds()
+	}
+	if o.maxLength > 0 {
+		args["x-max-length"] = o.maxLength
+	}
+	if o.maxLengthBytes > 0 {
+		args["x-max-length-bytes"] = o.maxLengthBytes
+	}
+	if o.overflow != "" {
+		args["x-overflow"] = string(o.overflow)
+	}
+	if o.maxPriority > 0 {
+		args["x-max-priority"] = o.maxPriority
+	}
+	return args
+}
+
+func (o queueOptions) consumeArgs() amqp.Table {
+	if o.streamOffset == nil {
+		return nil
+	}
+	offset := o.streamOffset
+	if i, ok := offset.(int); ok {
+		offset = int64(i)
+	}
+	return amqp.Table{"x-stream-offset": offset}
+}
+
 func DeclareAndBind(
 	conn *amqp.Connection,
 	exchange,
 	queueName,
 	key string,
-	simpleQueueType SimpleQueueType, // an enum to represent "durable" or "transient"
+	simpleQueueType SimpleQueueType, // an enum to represent "durable", "transient", "quorum", "stream" or "transient stream"
+	opts ...QueueOption,
 ) (*amqp.Channel, amqp.Queue, error) {
+	options := newQueueOptions(opts)
+	if err := options.validate(simpleQueueType); err != nil {
+		return nil, amqp.Queue{}, err
+	}
+
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, amqp.Queue{}, err
@@ -26,18 +292,15 @@ func DeclareAndBind(
 	durable := false
 	autoDelete := false
 	exclusive := false
-	if simpleQueueType == SimpleQueueTypeDurable {
+	switch simpleQueueType {
+	case SimpleQueueTypeDurable, SimpleQueueTypeQuorum, SimpleQueueTypeStream, SimpleQueueTypeTransientStream:
 		durable = true
-	}
-	if simpleQueueType == SimpleQueueTypeTransient {
+	case SimpleQueueTypeTransient:
 		autoDelete = true
 		exclusive = true
 	}
 
-	ampqTable := amqp.Table{
-		"x-dead-letter-exchange": "peril_dlx",
-	}
-	queue, err := ch.QueueDeclare(queueName, durable, autoDelete, exclusive, false, ampqTable)
+	queue, err := ch.QueueDeclare(queueName, durable, autoDelete, exclusive, false, options.declareArgs(simpleQueueType))
 	if err != nil {
 		return nil, amqp.Queue{}, err
 	}
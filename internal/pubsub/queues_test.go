@@ -0,0 +1,115 @@
+package pubsub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestQueueOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueType SimpleQueueType
+		opts      []QueueOption
+		wantErr   bool
+	}{
+		{"durable", SimpleQueueTypeDurable, nil, false},
+		{"unknown type", SimpleQueueType(0), nil, true},
+		{"delivery limit on quorum", SimpleQueueTypeQuorum, []QueueOption{WithDeliveryLimit(3)}, false},
+		{"delivery limit on classic", SimpleQueueTypeDurable, []QueueOption{WithDeliveryLimit(3)}, true},
+		{"negative delivery limit", SimpleQueueTypeQuorum, []QueueOption{WithDeliveryLimit(-1)}, true},
+		{"stream offset name", SimpleQueueTypeStream, []QueueOption{WithStreamOffset(StreamOffsetFirst)}, false},
+		{"stream offset number", SimpleQueueTypeTransientStream, []QueueOption{WithStreamOffset(int64(5))}, false},
+		{"stream offset time", SimpleQueueTypeStream, []QueueOption{WithStreamOffset(time.Now())}, false},
+		{"unknown stream offset", SimpleQueueTypeStream, []QueueOption{WithStreamOffset("middle")}, true},
+		{"negative stream offset", SimpleQueueTypeStream, []QueueOption{WithStreamOffset(-1)}, true},
+		{"stream offset on classic", SimpleQueueTypeDurable, []QueueOption{WithStreamOffset(StreamOffsetFirst)}, true},
+		{"ttl on classic", SimpleQueueTypeTransient, []QueueOption{WithMessageTTL(1500 * time.Millisecond)}, false},
+		{"ttl below a millisecond", SimpleQueueTypeDurable, []QueueOption{WithMessageTTL(time.Microsecond)}, true},
+		{"ttl on stream", SimpleQueueTypeStream, []QueueOption{WithMessageTTL(time.Minute)}, false},
+		{"ttl on stream below a second", SimpleQueueTypeStream, []QueueOption{WithMessageTTL(1500 * time.Millisecond)}, true},
+		{"negative ttl", SimpleQueueTypeDurable, []QueueOption{WithMessageTTL(-time.Second)}, true},
+		{"max length on stream", SimpleQueueTypeStream, []QueueOption{WithMaxLength(10)}, true},
+		{"max length bytes on stream", SimpleQueueTypeStream, []QueueOption{WithMaxLengthBytes(1 << 20)}, false},
+		{"overflow with max length", SimpleQueueTypeDurable, []QueueOption{WithMaxLength(10), WithOverflow(OverflowDropHead)}, false},
+		{"overflow without max length", SimpleQueueTypeDurable, []QueueOption{WithOverflow(OverflowDropHead)}, true},
+		{"unknown overflow", SimpleQueueTypeDurable, []QueueOption{WithMaxLength(10), WithOverflow("drop-tail")}, true},
+		{"overflow on stream", SimpleQueueTypeStream, []QueueOption{WithMaxLengthBytes(10), WithOverflow(OverflowRejectPublish)}, true},
+		{"reject-publish-dlx on quorum", SimpleQueueTypeQuorum, []QueueOption{WithMaxLength(10), WithOverflow(OverflowRejectPublishDLX)}, true},
+		{"priority on classic", SimpleQueueTypeTransient, []QueueOption{WithMaxPriority(PriorityHigh)}, false},
+		{"priority on quorum", SimpleQueueTypeQuorum, []QueueOption{WithMaxPriority(PriorityHigh)}, true},
+		{"priority on stream", SimpleQueueTypeStream, []QueueOption{WithMaxPriority(PriorityHigh)}, true},
+		{"rate limit", SimpleQueueTypeDurable, []QueueOption{WithRateLimitHeader(NewRateLimiter(5, 20, ThrottleDelay), "x-user")}, false},
+		{"rate limit without rate", SimpleQueueTypeDurable, []QueueOption{WithRateLimitHeader(NewRateLimiter(0, 20, ThrottleDelay), "x-user")}, true},
+		{"rate limit without burst", SimpleQueueTypeDurable, []QueueOption{WithRateLimitHeader(NewRateLimiter(5, 0, ThrottleDelay), "x-user")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newQueueOptions(tt.opts).validate(tt.queueType)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueueOptionsDeclareArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueType SimpleQueueType
+		opts      []QueueOption
+		want      amqp.Table
+	}{
+		{"classic", SimpleQueueTypeDurable, nil, amqp.Table{
+			"x-dead-letter-exchange": DeadLetterExchange,
+		}},
+		{"classic limits", SimpleQueueTypeTransient, []QueueOption{WithMessageTTL(time.Minute), WithMaxLength(100), WithOverflow(OverflowDropHead), WithMaxPriority(PriorityHigh)}, amqp.Table{
+			"x-dead-letter-exchange": DeadLetterExchange,
+			"x-message-ttl":          int64(60000),
+			"x-max-length":           100,
+			"x-overflow":             "drop-head",
+			"x-max-priority":         PriorityHigh,
+		}},
+		{"quorum", SimpleQueueTypeQuorum, []QueueOption{WithDeliveryLimit(5)}, amqp.Table{
+			"x-dead-letter-exchange": DeadLetterExchange,
+			"x-queue-type":           "quorum",
+			"x-delivery-limit":       5,
+		}},
+		{"stream", SimpleQueueTypeStream, []QueueOption{WithMaxLengthBytes(1024)}, amqp.Table{
+			"x-queue-type":       "stream",
+			"x-max-length-bytes": int64(1024),
+		}},
+		{"stream retention", SimpleQueueTypeStream, []QueueOption{WithMessageTTL(2 * time.Minute)}, amqp.Table{
+			"x-queue-type": "stream",
+			"x-max-age":    "120s",
+		}},
+		{"transient stream", SimpleQueueTypeTransientStream, nil, amqp.Table{
+			"x-queue-type": "stream",
+			"x-max-age":    "3600s",
+		}},
+		{"transient stream retention", SimpleQueueTypeTransientStream, []QueueOption{WithMessageTTL(time.Minute)}, amqp.Table{
+			"x-queue-type": "stream",
+			"x-max-age":    "60s",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newQueueOptions(tt.opts).declareArgs(tt.queueType)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("declareArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueOptionsConsumeArgs(t *testing.T) {
+	if args := newQueueOptions(nil).consumeArgs(); args != nil {
+		t.Errorf("consumeArgs() = %v, want nil", args)
+	}
+	args := newQueueOptions([]QueueOption{WithStreamOffset(7)}).consumeArgs()
+	if offset, ok := args["x-stream-offset"].(int64); !ok || offset != 7 {
+		t.Errorf("x-stream-offset = %#v, want int64(7)", args["x-stream-offset"])
+	}
+}
@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// ThrottleAction is what a subscriber does with a message whose sender is over its rate limit.
+type ThrottleAction int
+
+const (
+	// ThrottleDelay holds the message back until the sender has a token again. Only a few messages
+	// per subscription can be held back at once; the rest are dead-lettered.
+	ThrottleDelay ThrottleAction = iota
+	ThrottleDiscard
+	ThrottleDeadLetter
+)
+
+// throttledRetention is how long a sender's throttled count is kept after its last throttled
+// message.
+const throttledRetention = 10 * time.Minute
+
+// sweepInterval is how often the limiter forgets idle senders.
+const sweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens   float64
+	lastFill time.Time
+}
+
+type throttleCount struct {
+	count uint64
+	last  time.Time
+}
+
+// RateLimiter is a set of token buckets, one per sender key, shared by the subscriptions it is
+// passed to.
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	action ThrottleAction
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	throttled map[string]*throttleCount
+	lastSweep time.Time
+}
+
+// NewRateLimiter allows each sender rate messages per second on average, with bursts of up to
+// burst messages.
+func NewRateLimiter(rate float64, burst int, action ThrottleAction) *RateLimiter {
+	return &RateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		action:    action,
+		buckets:   map[string]*tokenBucket{},
+		throttled: map[string]*throttleCount{},
+		lastSweep: time.Now(),
+	}
+}
+
+// take removes a token from the sender's bucket. It returns how long the caller has to wait for
+// the token, and false if the sender is over its limit and the message should not be handled.
+func (rl *RateLimiter) take(key string) (time.Duration, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) >= sweepInterval {
+		rl.sweep(now)
+	}
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: rl.burst, lastFill: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.lastFill).Seconds() * rl.rate
+	if bucket.tokens > rl.burst {
+		bucket.tokens = rl.burst
+	}
+	bucket.lastFill = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, true
+	}
+	throttled, ok := rl.throttled[key]
+	if !ok {
+		throttled = &throttleCount{}
+		rl.throttled[key] = throttled
+	}
+	throttled.count++
+	throttled.last = now
+	if rl.action != ThrottleDelay {
+		return 0, false
+	}
+	// Borrow the token so that delayed messages queue up behind each other.
+	wait := time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	bucket.tokens--
+	return wait, true
+}
+
+// giveBack returns a token taken for a message that was not handled after all.
+func (rl *RateLimiter) giveBack(key string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if bucket, ok := rl.buckets[key]; ok {
+		bucket.tokens = min(bucket.tokens+1, rl.burst)
+	}
+}
+
+// sweep forgets the buckets that have filled up again, since a new bucket would be the same, and
+// the throttled counts older than throttledRetention.
+func (rl *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range rl.buckets {
+		if bucket.tokens+now.Sub(bucket.lastFill).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+	for key, throttled := range rl.throttled {
+		if now.Sub(throttled.last) >= throttledRetention {
+			delete(rl.throttled, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// Throttled returns how many messages each sender had delayed, discarded or dead-lettered, for the
+// senders throttled within the last throttledRetention.
+func (rl *RateLimiter) Throttled() map[string]uint64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	throttled := map[string]uint64{}
+	for k, v := range rl.throttled {
+		throttled[k] = v.count
+	}
+	return throttled
+}
+
+// WithRateLimit throttles messages per sender, using key to get the sender from a decoded message.
+func WithRateLimit[T any](limiter *RateLimiter, key func(T) string) QueueOption {
+	return func(o *queueOptions) {
+		o.limiter = limiter
+		o.limitKey = func(_ Message, val any) string {
+			v, ok := val.(T)
+			if !ok {
+				return ""
+			}
+			return key(v)
+		}
+	}
+}
+
+// WithRateLimitHeader throttles messages per sender, reading the sender from a message header.
+func WithRateLimitHeader(limiter *RateLimiter, header string) QueueOption {
+	return func(o *queueOptions) {
+		o.limiter = limiter
+		o.limitKey = func(msg Message, _ any) string {
+			v, _ := msg.Headers[header].(string)
+			return v
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	rl := NewRateLimiter(1, 2, ThrottleDeadLetter)
+	for i := 0; i < 2; i++ {
+		if wait, ok := rl.take("alice"); !ok || wait != 0 {
+			t.Fatalf("take %d = %v, %v, want 0, true", i, wait, ok)
+		}
+	}
+	if _, ok := rl.take("alice"); ok {
+		t.Fatal("take over the burst succeeded")
+	}
+	if _, ok := rl.take("bob"); !ok {
+		t.Fatal("bob was throttled for alice's messages")
+	}
+	if got := rl.Throttled(); got["alice"] != 1 || len(got) != 1 {
+		t.Errorf("Throttled() = %v, want alice: 1", got)
+	}
+}
+
+func TestRateLimiterDelay(t *testing.T) {
+	rl := NewRateLimiter(10, 1, ThrottleDelay)
+	rl.take("alice")
+	wait, ok := rl.take("alice")
+	if !ok || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("take = %v, %v, want a wait of up to 100ms", wait, ok)
+	}
+	// The token borrowed for the delayed message is due back, so the bucket can not go lower.
+	rl.giveBack("alice")
+	wait, _ = rl.take("alice")
+	if wait > 100*time.Millisecond {
+		t.Errorf("wait after giving a token back = %v, want at most 100ms", wait)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	rl := NewRateLimiter(1, 1, ThrottleDiscard)
+	rl.take("alice")
+	rl.take("alice")
+	rl.take("bob")
+
+	now := time.Now()
+	rl.sweep(now.Add(2 * time.Second))
+	if len(rl.buckets) != 0 {
+		t.Errorf("buckets after refilling = %d, want 0", len(rl.buckets))
+	}
+	if len(rl.throttled) != 1 {
+		t.Errorf("throttled counts before the retention = %d, want 1", len(rl.throttled))
+	}
+	rl.sweep(now.Add(throttledRetention + time.Second))
+	if len(rl.throttled) != 0 {
+		t.Errorf("throttled counts after the retention = %d, want 0", len(rl.throttled))
+	}
+}
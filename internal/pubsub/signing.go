@@ -0,0 +1,386 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultKeyStorePath is where the server keeps the public keys of the players it has seen.
+const DefaultKeyStorePath = "peril_known_keys.json"
+
+// The server writes its public key to ServerKeyPath when it starts. Clients trust the key in the file
+// named by ServerKeyEnv, or in ServerKeyPath if it is not set.
+const (
+	ServerKeyPath = "peril_server_key.json"
+	ServerKeyEnv  = "PERIL_SERVER_KEY"
+)
+
+// IdentityDirEnv names the directory private keys are kept in, instead of the peril directory in the
+// user's configuration directory.
+const IdentityDirEnv = "PERIL_IDENTITY_DIR"
+
+// PublicIdentity is the public half of an Identity, which anyone may see.
+type PublicIdentity struct {
+	Name          string            `json:"name"`
+	SigningKey    ed25519.PublicKey `json:"signing_key"`
+	EncryptionKey []byte            `json:"encryption_key"`
+}
+
+func (pub PublicIdentity) equal(other PublicIdentity) bool {
+	return pub.Name == other.Name && bytes.Equal(pub.SigningKey, other.SigningKey) && bytes.Equal(pub.EncryptionKey, other.EncryptionKey)
+}
+
+func (pub PublicIdentity) validate() error {
+	if len(pub.SigningKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid signing key for %s", pub.Name)
+	}
+	if _, err := ecdh.X25519().NewPublicKey(pub.EncryptionKey); err != nil {
+		return fmt.Errorf("invalid encryption key for %s", pub.Name)
+	}
+	return nil
+}
+
+// Identity is the pair of keys a player or the server signs and decrypts with. Each identity is kept
+// in a file of its own that only its owner can read.
+type Identity struct {
+	name          string
+	signingKey    ed25519.PrivateKey
+	encryptionKey *ecdh.PrivateKey
+}
+
+type identityFile struct {
+	Name          string `json:"name"`
+	SigningSeed   []byte `json:"signing_seed"`
+	EncryptionKey []byte `json:"encryption_key"`
+}
+
+// DefaultIdentityDir is the directory named by IdentityDirEnv, or the peril directory in the user's
+// configuration directory.
+func DefaultIdentityDir() (string, error) {
+	if dir := os.Getenv(IdentityDirEnv); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find a directory for keys: %w", err)
+	}
+	return filepath.Join(dir, "peril"), nil
+}
+
+func identityPath(dir, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("%q can not be used as a key name", name)
+	}
+	return filepath.Join(dir, "identity_"+name+".json"), nil
+}
+
+// LoadIdentity returns the identity of name kept in dir, generating it if there is none yet. Two
+// processes creating the same identity at once end up with the same key.
+func LoadIdentity(dir, name string) (*Identity, error) {
+	path, err := identityPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := readIdentity(path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return id, err
+	}
+	id, err = CreateIdentity(dir, name)
+	if errors.Is(err, fs.ErrExist) {
+		return readIdentity(path)
+	}
+	return id, err
+}
+
+// CreateIdentity generates a new identity for name in dir. It fails with fs.ErrExist if name
+// already has one.
+func CreateIdentity(dir, name string) (*Identity, error) {
+	path, err := identityPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate signing key: %w", err)
+	}
+	encryptionKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate encryption key: %w", err)
+	}
+	data, err := json.Marshal(identityFile{Name: name, SigningSeed: signingKey.Seed(), EncryptionKey: encryptionKey.Bytes()})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode identity: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create key directory: %w", err)
+	}
+	// The key is written in full to a temporary file first and then linked into place, which fails if
+	// another process got there first, so nobody ever reads half a key.
+	tmp, err := os.CreateTemp(dir, "identity_*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("could not write identity: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("could not write identity: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("could not write identity: %w", err)
+	}
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("%s already has a key: %w", name, fs.ErrExist)
+		}
+		return nil, fmt.Errorf("could not write identity: %w", err)
+	}
+	return &Identity{name: name, signingKey: signingKey, encryptionKey: encryptionKey}, nil
+}
+
+// DeleteIdentity removes the identity of name from dir.
+func DeleteIdentity(dir, name string) error {
+	path, err := identityPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("could not delete identity: %w", err)
+	}
+	return nil
+}
+
+func readIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f identityFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("could not parse identity %s: %w", path, err)
+	}
+	if len(f.SigningSeed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("identity %s has an invalid signing key", path)
+	}
+	encryptionKey, err := ecdh.X25519().NewPrivateKey(f.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("identity %s has an invalid encryption key", path)
+	}
+	return &Identity{name: f.Name, signingKey: ed25519.NewKeyFromSeed(f.SigningSeed), encryptionKey: encryptionKey}, nil
+}
+
+func (id *Identity) Name() string {
+	return id.name
+}
+
+func (id *Identity) Public() PublicIdentity {
+	return PublicIdentity{
+		Name:          id.name,
+		SigningKey:    id.signingKey.Public().(ed25519.PublicKey),
+		EncryptionKey: id.encryptionKey.PublicKey().Bytes(),
+	}
+}
+
+// SavePublic writes the public half of the identity to path, for others to trust.
+func (id *Identity) SavePublic(path string) error {
+	data, err := json.MarshalIndent(id.Public(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode public key: %w", err)
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write public key: %w", err)
+	}
+	return nil
+}
+
+// KeyStore holds the public keys messages are verified with. A store loaded from a file pins the key
+// of every new signer the first time one of their messages is seen, so a name belongs to whoever
+// used it first, and saves it so that names stay taken. Only one process may use the file.
+type KeyStore struct {
+	path string
+	mu   sync.Mutex
+	keys map[string]PublicIdentity
+}
+
+// NewKeyStore returns a store that only accepts the keys given to Trust.
+func NewKeyStore() *KeyStore {
+	return &KeyStore{keys: map[string]PublicIdentity{}}
+}
+
+// LoadKeyStore returns a store that pins new signers, starting with the keys pinned in path.
+func LoadKeyStore(path string) (*KeyStore, error) {
+	ks := &KeyStore{path: path, keys: map[string]PublicIdentity{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read key store: %w", err)
+	}
+	if err := json.Unmarshal(data, &ks.keys); err != nil {
+		return nil, fmt.Errorf("could not parse key store: %w", err)
+	}
+	return ks, nil
+}
+
+// Trust accepts pub for its name, replacing any key pinned for it before.
+func (ks *KeyStore) Trust(pub PublicIdentity) error {
+	if err := pub.validate(); err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[pub.Name] = pub
+	return ks.save()
+}
+
+// TrustFile trusts the public identity saved in path.
+func (ks *KeyStore) TrustFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read public key: %w", err)
+	}
+	var pub PublicIdentity
+	if err := json.Unmarshal(data, &pub); err != nil {
+		return fmt.Errorf("could not parse public key %s: %w", path, err)
+	}
+	return ks.Trust(pub)
+}
+
+// ServerKeyPathFromEnv is the file clients read the server's public key from.
+func ServerKeyPathFromEnv() string {
+	if path := os.Getenv(ServerKeyEnv); path != "" {
+		return path
+	}
+	return ServerKeyPath
+}
+
+func (ks *KeyStore) Key(name string) (PublicIdentity, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	pub, ok := ks.keys[name]
+	return pub, ok
+}
+
+// accept reports whether messages signed with pub may be trusted, pinning pub if its name is new and
+// the store pins new signers.
+func (ks *KeyStore) accept(pub PublicIdentity) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	known, ok := ks.keys[pub.Name]
+	if ok {
+		if !known.equal(pub) {
+			return fmt.Errorf("%s signed with a key other than the one it registered", pub.Name)
+		}
+		return nil
+	}
+	if ks.path == "" {
+		return fmt.Errorf("unknown signer %s", pub.Name)
+	}
+	ks.keys[pub.Name] = pub
+	if err := ks.save(); err != nil {
+		delete(ks.keys, pub.Name)
+		return err
+	}
+	return nil
+}
+
+func (ks *KeyStore) save() error {
+	if ks.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ks.keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode key store: %w", err)
+	}
+	if err := writeFileAtomic(ks.path, data, 0644); err != nil {
+		return fmt.Errorf("could not write key store: %w", err)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// WithSigner signs the message with id. The signature covers the body and the routing key.
+func WithSigner(id *Identity) PublishOption {
+	return func(o *publishOptions) {
+		o.identity = id
+	}
+}
+
+// WithVerifier rejects messages to the dead letter exchange unless they are signed by the player
+// that claimed returns for the decoded message.
+func WithVerifier[T any](ks *KeyStore, claimed func(T) string) QueueOption {
+	return func(o *queueOptions) {
+		o.keyStore = ks
+		o.claimedSigner = func(val any) string {
+			v, ok := val.(T)
+			if !ok {
+				return ""
+			}
+			return claimed(v)
+		}
+	}
+}
+
+// open unwraps a signed or encrypted message, checking its signature if the subscriber verifies
+// signatures and decrypting it if it is for the subscriber. It returns the payload and who signed it.
+func (o queueOptions) open(routingKey string, body []byte) ([]byte, string, error) {
+	env, ok, err := parseEnvelope(body)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		if o.keyStore != nil {
+			return nil, "", errNotSigned
+		}
+		return body, "", nil
+	}
+
+	var signer string
+	if o.keyStore != nil {
+		if env.Signer == "" || len(env.Signature) == 0 {
+			return nil, "", errNotSigned
+		}
+		pub := PublicIdentity{Name: env.Signer, SigningKey: env.SigningKey, EncryptionKey: env.EncryptionKey}
+		if err := pub.validate(); err != nil {
+			return nil, "", err
+		}
+		// The signature is checked before the key is pinned, so nobody can take a name with a forgery.
+		if !ed25519.Verify(env.SigningKey, env.signedData(routingKey), env.Signature) {
+			return nil, "", fmt.Errorf("invalid signature from %s", env.Signer)
+		}
+		if err := o.keyStore.accept(pub); err != nil {
+			return nil, "", err
+		}
+		signer = env.Signer
+	}
+
+	if env.Recipient == "" {
+		return env.Payload, signer, nil
+	}
+	if o.identity == nil || o.identity.name != env.Recipient {
+		return nil, "", errNotRecipient
+	}
+	payload, err := o.identity.decrypt(routingKey, env.Payload)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, signer, nil
+}
@@ -0,0 +1,141 @@
+package pubsub
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLoadIdentityConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	ids := make([]*Identity, 8)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, err := LoadIdentity(dir, "alice")
+			if err != nil {
+				t.Errorf("LoadIdentity: %v", err)
+				return
+			}
+			ids[i] = id
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids[1:] {
+		if id == nil || ids[0] == nil || !bytes.Equal(id.Public().SigningKey, ids[0].Public().SigningKey) {
+			t.Fatal("processes creating the same identity got different keys")
+		}
+	}
+	if _, err := CreateIdentity(dir, "alice"); err == nil {
+		t.Error("CreateIdentity replaced an existing identity")
+	}
+	if _, err := LoadIdentity(dir, "../alice"); err == nil {
+		t.Error("LoadIdentity accepted a name outside the key directory")
+	}
+}
+
+func signedBy(t *testing.T, id *Identity, key string, body []byte) []byte {
+	t.Helper()
+	signed, err := newPublishOptions([]PublishOption{WithSigner(id)}).wrap(key, "application/json", body)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func TestVerifySignature(t *testing.T) {
+	dir := t.TempDir()
+	alice, err := LoadIdentity(dir, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := LoadKeyStore(filepath.Join(dir, "known.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newQueueOptions([]QueueOption{WithVerifier(ks, func(s string) string { return s })})
+
+	body, signer, err := o.open("lobby.alice", signedBy(t, alice, "lobby.alice", []byte(`"hi"`)))
+	if err != nil || signer != "alice" || string(body) != `"hi"` {
+		t.Fatalf("open = %q, %q, %v, want the body signed by alice", body, signer, err)
+	}
+	if _, _, err := o.open("lobby.bob", signedBy(t, alice, "lobby.alice", []byte(`"hi"`))); err == nil {
+		t.Error("a message replayed to another routing key was accepted")
+	}
+	if _, _, err := o.open("lobby.alice", []byte(`"hi"`)); err == nil {
+		t.Error("an unsigned message was accepted")
+	}
+
+	// alice's key is pinned now, so a second key for the name is refused, even after a restart.
+	impostor, err := LoadIdentity(t.TempDir(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadKeyStore(filepath.Join(dir, "known.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o = newQueueOptions([]QueueOption{WithVerifier(reloaded, func(s string) string { return s })})
+	if _, _, err := o.open("lobby.alice", signedBy(t, impostor, "lobby.alice", []byte(`"hi"`))); err == nil {
+		t.Error("a message signed with another key for alice was accepted")
+	}
+}
+
+func TestForgeryDoesNotPin(t *testing.T) {
+	dir := t.TempDir()
+	bob, err := LoadIdentity(dir, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := LoadKeyStore(filepath.Join(dir, "known.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newQueueOptions([]QueueOption{WithVerifier(ks, func(s string) string { return s })})
+
+	env, _, err := parseEnvelope(signedBy(t, bob, "lobby.bob", []byte(`"hi"`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Payload = []byte(`"bye"`)
+	forged, err := env.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := o.open("lobby.bob", forged); err == nil {
+		t.Fatal("a tampered message was accepted")
+	}
+	if _, ok := ks.Key("bob"); ok {
+		t.Error("a forged message pinned a key")
+	}
+}
+
+func TestKeyStoreOnlyTrusted(t *testing.T) {
+	dir := t.TempDir()
+	server, err := LoadIdentity(dir, "peril_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := LoadIdentity(dir, "mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "server.json")
+	if err := server.SavePublic(path); err != nil {
+		t.Fatal(err)
+	}
+	ks := NewKeyStore()
+	if err := ks.TrustFile(path); err != nil {
+		t.Fatal(err)
+	}
+	o := newQueueOptions([]QueueOption{WithVerifier(ks, func(s string) string { return s })})
+	if _, _, err := o.open("k", signedBy(t, server, "k", []byte(`"ok"`))); err != nil {
+		t.Errorf("the trusted key was refused: %v", err)
+	}
+	if _, _, err := o.open("k", signedBy(t, mallory, "k", []byte(`"ok"`))); err == nil {
+		t.Error("a key that was never trusted was accepted")
+	}
+}
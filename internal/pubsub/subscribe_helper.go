@@ -1,7 +1,10 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -14,6 +17,17 @@ const (
 	NackRequeue
 )
 
+// prefetchCount is how many unacknowledged messages a subscriber holds at a time.
+const prefetchCount = 10
+
+// maxDelayed is how many of the prefetched messages can wait for their sender's rate limit.
+const maxDelayed = prefetchCount / 2
+
+type delayedDelivery[T any] struct {
+	msg Delivery
+	val T
+}
+
 func subscribe[T any](
 	conn *amqp.Connection,
 	exchange,
@@ -22,46 +36,172 @@ func subscribe[T any](
 	simpleQueueType SimpleQueueType,
 	handler func(T) AckType,
 	unmarshaller func([]byte) (T, error),
+	opts ...QueueOption,
 ) error {
-	ch, queue, err := DeclareAndBind(conn, exchange, queueName, key, simpleQueueType)
+	options := newQueueOptions(opts)
+	ch, queue, err := DeclareAndBind(conn, exchange, queueName, key, simpleQueueType, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to declare and bind queue: %w", err)
 	}
 
-	err = ch.Qos(10, 0, false)
+	err = ch.Qos(prefetchCount, 0, false)
 	if err != nil {
 		return fmt.Errorf("failed to set QoS: %w", err)
 	}
 
-	deliveryCh, err := ch.Consume(queue.Name, "", false, false, false, false, nil)
+	deliveryCh, err := ch.Consume(queue.Name, "", false, false, false, false, options.consumeArgs())
 	if err != nil {
 		return fmt.Errorf("failed to consume messages: %w", err)
 	}
 
+	// Streams can not dead-letter, so rejected messages are republished to the dead letter exchange
+	// and acknowledged instead.
+	reject := func(msg amqp.Delivery) error {
+		if !simpleQueueType.isStream() {
+			return msg.Nack(false, false)
+		}
+		if err := deadLetter(ch, queue.Name, msg); err != nil {
+			fmt.Printf("failed to dead-letter message %s: %v\n", msg.RoutingKey, err)
+		}
+		return msg.Ack(false)
+	}
+
+	deliveries := make(chan Delivery)
 	go func() {
+		defer close(deliveries)
 		for msg := range deliveryCh {
-			val, err := unmarshaller(msg.Body)
-			if err != nil {
-				fmt.Printf("failed to unmarshal message: %v\n", err)
-				continue
-			}
-			ackType := handler(val)
-			switch ackType {
-			case Ack:
-				fmt.Printf("Ack-ing message: %s\n", msg.RoutingKey)
-				msg.Ack(false)
-			case NackRequeue:
-				fmt.Printf("Nack-ing and requeue message: %s\n", msg.RoutingKey)
-				msg.Nack(false, true)
-			case NackDiscard:
-				fmt.Printf("Nack-ing and discard message: %s\n", msg.RoutingKey)
-				msg.Nack(false, false)
-			default:
-				fmt.Printf("unknown ack type: %v\n", ackType)
-				msg.Nack(false, false)
+			msg := msg
+			deliveries <- Delivery{
+				Message: Message{
+					RoutingKey:  msg.RoutingKey,
+					ContentType: msg.ContentType,
+					Headers:     msg.Headers,
+					Body:        msg.Body,
+				},
+				Ack: func() error { return msg.Ack(false) },
+				Nack: func(requeue bool) error {
+					if requeue {
+						return msg.Nack(false, true)
+					}
+					return reject(msg)
+				},
 			}
 		}
-
 	}()
+	go consume(deliveries, options, handler, unmarshaller)
 	return nil
 }
+
+// consume opens, decodes, throttles and handles every delivery until deliveryCh is closed. The
+// AMQP subscriber and the transports share it, so messages are checked the same way whatever
+// protocol they came over.
+func consume[T any](deliveryCh <-chan Delivery, options queueOptions, handler func(T) AckType, unmarshaller func([]byte) (T, error)) {
+	handle := func(msg Delivery, val T) {
+		settle(handler(val), msg.RoutingKey, msg.Ack, msg.Nack)
+	}
+
+	// Delayed messages stay unacknowledged until their sender has a token again, and are then
+	// handed back to the consumer loop, so the handler never runs on two goroutines at once. Each
+	// delayed message holds one of the prefetched deliveries, so at most maxDelayed of them may
+	// wait at a time and a single sender can not stall the queue for everyone else.
+	delayed := make(chan delayedDelivery[T], maxDelayed)
+	waiting := 0
+
+	receive := func(msg Delivery) {
+		body, signer, err := options.open(msg.RoutingKey, msg.Body)
+		if errors.Is(err, errNotRecipient) {
+			fmt.Printf("Skipping message %s: %v\n", msg.RoutingKey, err)
+			msg.Ack()
+			return
+		}
+		if err != nil {
+			fmt.Printf("Rejecting message %s: %v\n", msg.RoutingKey, err)
+			msg.Nack(false)
+			return
+		}
+		val, err := unmarshaller(body)
+		if err != nil {
+			fmt.Printf("failed to unmarshal message: %v\n", err)
+			msg.Nack(false)
+			return
+		}
+		if options.keyStore != nil {
+			if claimed := options.claimedSigner(val); claimed != signer {
+				fmt.Printf("Rejecting message %s: signed by %s but claims to be from %s\n", msg.RoutingKey, signer, claimed)
+				msg.Nack(false)
+				return
+			}
+		}
+		if options.limiter != nil {
+			limitKey := options.limitKey(msg.Message, val)
+			wait, ok := options.limiter.take(limitKey)
+			if ok && wait > 0 && waiting >= maxDelayed {
+				options.limiter.giveBack(limitKey)
+				ok = false
+			}
+			if !ok {
+				if options.limiter.action == ThrottleDiscard {
+					fmt.Printf("Rate limited, discarding message: %s\n", msg.RoutingKey)
+					msg.Ack()
+				} else {
+					fmt.Printf("Rate limited, dead-lettering message: %s\n", msg.RoutingKey)
+					msg.Nack(false)
+				}
+				return
+			}
+			if wait > 0 {
+				fmt.Printf("Rate limited, delaying message by %s: %s\n", wait.Round(time.Millisecond), msg.RoutingKey)
+				waiting++
+				time.AfterFunc(wait, func() { delayed <- delayedDelivery[T]{msg: msg, val: val} })
+				return
+			}
+		}
+		handle(msg, val)
+	}
+
+	for {
+		select {
+		case msg, ok := <-deliveryCh:
+			if !ok {
+				return
+			}
+			receive(msg)
+		case d := <-delayed:
+			waiting--
+			handle(d.msg, d.val)
+		}
+	}
+}
+
+// deadLetter publishes msg to the dead letter exchange the way the broker would have.
+func deadLetter(ch *amqp.Channel, queueName string, msg amqp.Delivery) error {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["x-first-death-queue"] = queueName
+	headers["x-first-death-reason"] = "rejected"
+	headers["x-first-death-exchange"] = msg.Exchange
+	return ch.PublishWithContext(context.Background(), DeadLetterExchange, msg.RoutingKey, false, false, amqp.Publishing{
+		ContentType: msg.ContentType,
+		Headers:     headers,
+		Body:        msg.Body,
+	})
+}
+
+func settle(ackType AckType, routingKey string, ack func() error, nack func(requeue bool) error) {
+	switch ackType {
+	case Ack:
+		fmt.Printf("Ack-ing message: %s\n", routingKey)
+		ack()
+	case NackRequeue:
+		fmt.Printf("Nack-ing and requeue message: %s\n", routingKey)
+		nack(true)
+	case NackDiscard:
+		fmt.Printf("Nack-ing and discard message: %s\n", routingKey)
+		nack(false)
+	default:
+		fmt.Printf("unknown ack type: %v\n", ackType)
+		nack(false)
+	}
+}
@@ -0,0 +1,129 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Message is a message as seen by a Transport, independent of the wire protocol.
+type Message struct {
+	RoutingKey  string
+	ContentType string
+	Headers     map[string]any
+	Body        []byte
+	// Expiration and Priority are only set on published messages, as asked with WithExpiration
+	// and WithPriority. Transports that can not carry them refuse the message.
+	Expiration time.Duration
+	Priority   uint8
+}
+
+// Delivery is a received message along with the means to settle it.
+type Delivery struct {
+	Message
+	Ack  func() error
+	Nack func(requeue bool) error
+}
+
+// Transport lets the publish and subscribe helpers run over a protocol other than AMQP, for
+// clients that can not speak AMQP directly. Exchange and routing key semantics are those of the
+// Peril exchanges; each transport maps them onto its own addressing.
+type Transport interface {
+	Publish(exchange, key string, msg Message) error
+	// Subscribe declares the queue with args, the queue and consumer arguments of the options it
+	// was given, and binds it to key.
+	Subscribe(exchange, queueName, key string, simpleQueueType SimpleQueueType, args map[string]any) (<-chan Delivery, error)
+	Close() error
+}
+
+func PublishJSONTransport[T any](t Transport, exchange, key string, val T, opts ...PublishOption) error {
+	body, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return publishTransport(t, exchange, key, "application/json", body, opts)
+}
+
+func PublishGOBTransport[T any](t Transport, exchange, key string, val T, opts ...PublishOption) error {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(val); err != nil {
+		return fmt.Errorf("failed to encode with GOB: %w", err)
+	}
+	return publishTransport(t, exchange, key, "application/gob", buffer.Bytes(), opts)
+}
+
+func publishTransport(t Transport, exchange, key, contentType string, body []byte, opts []PublishOption) error {
+	msg, err := newPublishOptions(opts).prepare(key, contentType, body)
+	if err != nil {
+		return err
+	}
+	if err := t.Publish(exchange, key, msg); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	return nil
+}
+
+func SubscribeJSONTransport[T any](
+	t Transport,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+	handler func(T) AckType,
+	opts ...QueueOption,
+) error {
+	return subscribeTransport(t, exchange, queueName, key, simpleQueueType, handler, opts, func(data []byte) (T, error) {
+		var val T
+		if err := json.Unmarshal(data, &val); err != nil {
+			return val, fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+		return val, nil
+	})
+}
+
+func SubscribeGOBTransport[T any](
+	t Transport,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+	handler func(T) AckType,
+	opts ...QueueOption,
+) error {
+	return subscribeTransport(t, exchange, queueName, key, simpleQueueType, handler, opts, func(data []byte) (T, error) {
+		var val T
+		if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&val); err != nil {
+			return val, fmt.Errorf("failed to unmarshal GOB: %w", err)
+		}
+		return val, nil
+	})
+}
+
+func subscribeTransport[T any](
+	t Transport,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+	handler func(T) AckType,
+	opts []QueueOption,
+	unmarshaller func([]byte) (T, error),
+) error {
+	options := newQueueOptions(opts)
+	if err := options.validate(simpleQueueType); err != nil {
+		return err
+	}
+	args := map[string]any(options.declareArgs(simpleQueueType))
+	for name, value := range options.consumeArgs() {
+		args[name] = value
+	}
+
+	deliveryCh, err := t.Subscribe(exchange, queueName, key, simpleQueueType, args)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+	go consume(deliveryCh, options, handler, unmarshaller)
+	return nil
+}
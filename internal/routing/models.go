@@ -2,8 +2,29 @@ package routing
 
 import "time"
 
+// Turn events the server publishes with a PlayingState in turn mode.
+const (
+	TurnStarted = "turn_started"
+	TurnEnded   = "turn_ended"
+)
+
+// PlayingState is published whenever the game is paused or resumed. In turn mode it also announces
+// the start and end of every turn, with Event set and IsPaused false.
 type PlayingState struct {
 	IsPaused bool
+	Event    string
+	Turn     int
+	TurnEnds time.Time
+}
+
+// HeartbeatInterval is how often players tell the server they are still there.
+const HeartbeatInterval = 5 * time.Second
+
+// Heartbeat tells the server a player is still in a game. Leaving is set when the player quits.
+type Heartbeat struct {
+	GameID   string
+	Username string
+	Leaving  bool
 }
 
 type GameLog struct {
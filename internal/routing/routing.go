@@ -1,5 +1,11 @@
 package routing
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
 const (
 	ArmyMovesPrefix = "army_moves"
 
@@ -8,9 +14,56 @@ const (
 	PauseKey = "pause"
 
 	GameLogSlug = "game_logs"
+
+	GameCommandsPrefix = "game_commands"
+
+	GameDeltasPrefix = "game_deltas"
+
+	LobbyPrefix = "lobby"
+
+	LobbyRepliesPrefix = "lobby_replies"
+
+	HeartbeatPrefix = "heartbeat"
+)
+
+// GamePrefix namespaces the routing keys of every game, so that several games can share a broker.
+// Players join DefaultGameID unless they pick another game.
+const (
+	GamePrefix    = "game"
+	DefaultGameID = "main"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
 )
+
+// ServerSigner is the name the server signs its messages with. Players can not use it.
+const ServerSigner = "peril_server"
+
+const maxUsernameLength = 32
+
+// ValidUsername makes sure a username is one word of a routing key, so it can not match or bind to
+// the keys of other players.
+func ValidUsername(username string) error {
+	if username == "" {
+		return errors.New("the username is empty")
+	}
+	if len(username) > maxUsernameLength {
+		return fmt.Errorf("usernames can be at most %d characters", maxUsernameLength)
+	}
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("usernames can only have letters, digits, - and _, not %q", r)
+		}
+	}
+	if username == ServerSigner {
+		return fmt.Errorf("the username %s is reserved for the server", username)
+	}
+	return nil
+}
+
+// GameKey returns the routing key of parts in a game, such as game.<id>.game_deltas.<user>.
+func GameKey(gameID string, parts ...string) string {
+	return strings.Join(append([]string{GamePrefix, gameID}, parts...), ".")
+}
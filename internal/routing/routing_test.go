@@ -0,0 +1,26 @@
+package routing
+
+import "testing"
+
+func TestValidUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"alice", true},
+		{"Bob_2-b", true},
+		{"", false},
+		{"lobby_replies.#", false},
+		{"alice.bob", false},
+		{"*", false},
+		{"a b", false},
+		{"../alice", false},
+		{ServerSigner, false},
+		{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+	}
+	for _, tt := range tests {
+		if err := ValidUsername(tt.username); (err == nil) != tt.valid {
+			t.Errorf("ValidUsername(%q) = %v, expected valid: %v", tt.username, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Army moves are only interesting while they are fresh, so stale deltas are expired and a slow
+// client asks for a snapshot to catch up on them. The queue has no length limit: it would drop its
+// head, which is where the pause and turn changes wait.
+const armyMovesTTL = 30 * time.Second
+
+// Handlers are told about every message a session received, after it has been applied to the game
+// state. Error gets the failures of work the session does on its own, such as joining a game the
+// lobby let the player into. Any of them may be nil.
+type Handlers struct {
+	Lobby func(gamelogic.LobbyReply)
+	Pause func(routing.PlayingState)
+	Delta func(gamelogic.StateDelta)
+	Error func(error)
+}
+
+// Session is one player talking to the server: the lobby, the game they play in and the keys their
+// messages are signed and encrypted with. The terminal client and the gateway each show what
+// happens in their own way through Handlers.
+type Session struct {
+	conn      *amqp.Connection
+	publishCh *amqp.Channel
+	gameState *gamelogic.GameState
+	identity  *pubsub.Identity
+	keyStore  *pubsub.KeyStore
+	handlers  Handlers
+
+	signer      pubsub.PublishOption
+	commandOpts []pubsub.PublishOption
+}
+
+// New starts a session for the player of gs and subscribes to their lobby replies. Only the
+// server's messages are verified, so keyStore has to trust the server's key.
+func New(conn *amqp.Connection, gs *gamelogic.GameState, identity *pubsub.Identity, keyStore *pubsub.KeyStore, handlers Handlers) (*Session, error) {
+	username := gs.GetUsername()
+	if err := routing.ValidUsername(username); err != nil {
+		return nil, err
+	}
+	if identity.Name() != username {
+		return nil, fmt.Errorf("the key of %s can not sign for %s", identity.Name(), username)
+	}
+	publishCh, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("could not create channel: %w", err)
+	}
+
+	signer := pubsub.WithSigner(identity)
+	s := &Session{
+		conn:      conn,
+		publishCh: publishCh,
+		gameState: gs,
+		identity:  identity,
+		keyStore:  keyStore,
+		handlers:  handlers,
+		signer:    signer,
+		// Commands can only be read by the server.
+		commandOpts: []pubsub.PublishOption{signer, pubsub.WithEncryption(keyStore, routing.ServerSigner)},
+	}
+
+	key := routing.LobbyRepliesPrefix + "." + username
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, key, key, pubsub.SimpleQueueTypeTransient, s.handlerLobby(),
+		pubsub.WithVerifier(keyStore, func(reply gamelogic.LobbyReply) string { return routing.ServerSigner }),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not subscribe to lobby replies queue: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Session) username() string {
+	return s.gameState.GetUsername()
+}
+
+func (s *Session) reportError(err error) {
+	if s.handlers.Error != nil {
+		s.handlers.Error(err)
+	}
+}
+
+// Lobby sends req to the lobby. The reply arrives through Handlers.Lobby, and a game the player was
+// let into is joined before it is handed on.
+func (s *Session) Lobby(req gamelogic.LobbyRequest) error {
+	return pubsub.PublishJSON(s.publishCh, routing.ExchangePerilTopic, routing.LobbyPrefix+"."+s.username(), req, s.signer)
+}
+
+// Command sends req to the game it names. The outcome arrives later as a delta.
+func (s *Session) Command(req gamelogic.CommandRequest) error {
+	return pubsub.PublishJSON(s.publishCh, routing.ExchangePerilTopic, routing.GameKey(req.GameID, routing.GameCommandsPrefix, s.username()), req, s.commandOpts...)
+}
+
+// Log sends a game log to the server.
+func (s *Session) Log(gameLog routing.GameLog) error {
+	return pubsub.PublishGOB(s.publishCh, routing.ExchangePerilTopic, routing.GameLogSlug+"."+s.username(), gameLog, s.signer)
+}
+
+// Leave tells the server the player quit, so it does not have to wait for the heartbeats to stop.
+func (s *Session) Leave() error {
+	gameID := s.gameState.GetGameID()
+	if gameID == "" {
+		return nil
+	}
+	hb := routing.Heartbeat{GameID: gameID, Username: s.username(), Leaving: true}
+	return pubsub.PublishJSON(s.publishCh, routing.ExchangePerilTopic, routing.GameKey(gameID, routing.HeartbeatPrefix, s.username()), hb, s.signer)
+}
+
+// joinGame subscribes to a game the lobby let the player into and asks for its state.
+func (s *Session) joinGame(gameID string) error {
+	username := s.username()
+	// Deltas can only be read by the player they were sent to. Pause and turn changes come in the
+	// same queue at high priority, ahead of the moves still waiting there.
+	key := routing.GameKey(gameID, routing.GameDeltasPrefix, username)
+	err := pubsub.SubscribeJSON(s.conn, routing.ExchangePerilTopic, key, key, pubsub.SimpleQueueTypeTransient, s.handlerDelta(),
+		pubsub.WithVerifier(s.keyStore, func(delta gamelogic.StateDelta) string { return routing.ServerSigner }),
+		pubsub.WithDecryption(s.identity),
+		pubsub.WithMessageTTL(armyMovesTTL),
+		pubsub.WithMaxPriority(pubsub.PriorityHigh),
+	)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to game deltas queue: %w", err)
+	}
+	s.gameState.SetGameID(gameID)
+	go s.sendHeartbeats(gameID)
+	err = s.Command(gamelogic.CommandRequest{GameID: gameID, Username: username, Command: gamelogic.CommandKindSync})
+	if err != nil {
+		return fmt.Errorf("could not request the game state: %w", err)
+	}
+	return nil
+}
+
+// sendHeartbeats tells the server the player is still in the game until publishing fails.
+func (s *Session) sendHeartbeats(gameID string) {
+	hb := routing.Heartbeat{GameID: gameID, Username: s.username()}
+	key := routing.GameKey(gameID, routing.HeartbeatPrefix, hb.Username)
+	ticker := time.NewTicker(routing.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		if err := pubsub.PublishJSON(s.publishCh, routing.ExchangePerilTopic, key, hb, s.signer); err != nil {
+			s.reportError(fmt.Errorf("could not send heartbeat: %w", err))
+			return
+		}
+		<-ticker.C
+	}
+}
+
+func (s *Session) handlerLobby() func(gamelogic.LobbyReply) pubsub.AckType {
+	return func(reply gamelogic.LobbyReply) pubsub.AckType {
+		if s.gameState.HandleLobbyReply(reply) {
+			if err := s.joinGame(reply.GameID); err != nil {
+				s.reportError(fmt.Errorf("could not join the game: %w", err))
+			}
+		}
+		if s.handlers.Lobby != nil {
+			s.handlers.Lobby(reply)
+		}
+		return pubsub.Ack
+	}
+}
+
+func (s *Session) handlerDelta() func(gamelogic.StateDelta) pubsub.AckType {
+	return func(delta gamelogic.StateDelta) pubsub.AckType {
+		if s.gameState.HandleDelta(delta) {
+			err := s.Command(gamelogic.CommandRequest{GameID: s.gameState.GetGameID(), Username: s.username(), Command: gamelogic.CommandKindSync})
+			if err != nil {
+				s.reportError(fmt.Errorf("could not request the game state: %w", err))
+			}
+		}
+		if delta.Kind == gamelogic.DeltaKindPlaying {
+			if s.handlers.Pause != nil && delta.Playing != nil {
+				s.handlers.Pause(*delta.Playing)
+			}
+			return pubsub.Ack
+		}
+		if s.handlers.Delta != nil {
+			s.handlers.Delta(delta)
+		}
+		return pubsub.Ack
+	}
+}
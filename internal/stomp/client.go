@@ -0,0 +1,300 @@
+package stomp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+type AckMode string
+
+const (
+	AckAuto             AckMode = "auto"
+	AckClient           AckMode = "client"
+	AckClientIndividual AckMode = "client-individual"
+)
+
+// Messages a subscription may hold before it stops reading from the connection. Subscriptions
+// made by Transport ask the broker for the same prefetch, so the reader never blocks on them.
+const subscriptionBuffer = 10
+
+var ErrClosed = errors.New("stomp connection is closed")
+
+// Client is a STOMP 1.2 client connection. It is safe for concurrent use.
+type Client struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	subs     map[string]*Subscription
+	receipts map[string]chan struct{}
+	nextID   int
+	err      error
+	done     chan struct{}
+}
+
+type Subscription struct {
+	ID          string
+	Destination string
+	Ack         AckMode
+	C           <-chan *Frame
+
+	client *Client
+	ch     chan *Frame
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (s *Subscription) deliver(f *Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- f:
+	case <-s.done:
+	}
+}
+
+// close ends the subscription channel. Closing done first releases a deliver blocked on a full
+// channel, so that the channel can be closed under the lock.
+func (s *Subscription) close() {
+	s.once.Do(func() { close(s.done) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// Dial connects to a STOMP broker such as RabbitMQ with the rabbitmq_stomp plugin, which listens
+// on port 61613 by default.
+func Dial(addr, login, passcode string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Connect(conn, login, passcode, "/")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Connect performs the STOMP handshake over an established connection.
+func Connect(conn net.Conn, login, passcode, host string) (*Client, error) {
+	connect := NewFrame(CommandConnect,
+		"accept-version", "1.2",
+		"host", host,
+		"login", login,
+		"passcode", passcode,
+		"heart-beat", "0,0",
+	)
+	if _, err := connect.WriteTo(conn); err != nil {
+		return nil, fmt.Errorf("could not send CONNECT: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	f, err := ReadFrame(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CONNECTED: %w", err)
+	}
+	if f.Command == CommandError {
+		return nil, fmt.Errorf("connection refused: %s", f.Get("message"))
+	}
+	if f.Command != CommandConnected {
+		return nil, fmt.Errorf("unexpected %s frame while connecting", f.Command)
+	}
+	if version := f.Get("version"); version != "1.2" {
+		return nil, fmt.Errorf("unsupported STOMP version %q", version)
+	}
+
+	c := &Client{
+		conn:     conn,
+		subs:     map[string]*Subscription{},
+		receipts: map[string]chan struct{}{},
+		done:     make(chan struct{}),
+	}
+	go c.readLoop(r)
+	return c, nil
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		f, err := ReadFrame(r)
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		switch f.Command {
+		case CommandMessage:
+			c.mu.Lock()
+			sub, ok := c.subs[f.Get("subscription")]
+			c.mu.Unlock()
+			if ok {
+				sub.deliver(f)
+			}
+		case CommandReceipt:
+			c.mu.Lock()
+			done, ok := c.receipts[f.Get("receipt-id")]
+			delete(c.receipts, f.Get("receipt-id"))
+			c.mu.Unlock()
+			if ok {
+				close(done)
+			}
+		case CommandError:
+			c.shutdown(fmt.Errorf("broker error: %s %s", f.Get("message"), f.Body))
+			return
+		}
+	}
+}
+
+// shutdown records why the connection ended and releases everyone waiting on it.
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	for id, sub := range c.subs {
+		sub.close()
+		delete(c.subs, id)
+	}
+	c.conn.Close()
+}
+
+// Err returns why the connection was closed, or nil while it is open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) newID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	return strconv.Itoa(c.nextID)
+}
+
+func (c *Client) write(f *Frame) error {
+	if err := c.Err(); err != nil {
+		return ErrClosed
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := f.WriteTo(c.conn)
+	return err
+}
+
+// writeWithReceipt sends f and waits until the broker has processed it.
+func (c *Client) writeWithReceipt(f *Frame) error {
+	id := c.newID()
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.receipts[id] = done
+	c.mu.Unlock()
+
+	f.Set("receipt", id)
+	if err := c.write(f); err != nil {
+		c.mu.Lock()
+		delete(c.receipts, id)
+		c.mu.Unlock()
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-c.done:
+		return c.Err()
+	}
+}
+
+// Send publishes body to destination. Extra headers are given as name, value pairs.
+func (c *Client) Send(destination, contentType string, body []byte, headers ...string) error {
+	f := NewFrame(CommandSend, headers...)
+	f.Set("destination", destination)
+	if contentType != "" {
+		f.Set("content-type", contentType)
+	}
+	f.Body = body
+	return c.write(f)
+}
+
+// Subscribe starts receiving the messages sent to destination. Extra headers are given as name,
+// value pairs.
+func (c *Client) Subscribe(destination string, ack AckMode, headers ...string) (*Subscription, error) {
+	id := c.newID()
+	sub := &Subscription{
+		ID:          id,
+		Destination: destination,
+		Ack:         ack,
+		client:      c,
+		ch:          make(chan *Frame, subscriptionBuffer),
+		done:        make(chan struct{}),
+	}
+	sub.C = sub.ch
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	c.subs[id] = sub
+	c.mu.Unlock()
+
+	f := NewFrame(CommandSubscribe, headers...)
+	f.Set("id", id)
+	f.Set("destination", destination)
+	f.Set("ack", string(ack))
+	if err := c.writeWithReceipt(f); err != nil {
+		c.removeSubscription(id)
+		return nil, fmt.Errorf("could not subscribe to %s: %w", destination, err)
+	}
+	return sub, nil
+}
+
+func (c *Client) removeSubscription(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sub, ok := c.subs[id]; ok {
+		sub.close()
+		delete(c.subs, id)
+	}
+}
+
+func (s *Subscription) Unsubscribe() error {
+	defer s.client.removeSubscription(s.ID)
+	return s.client.write(NewFrame(CommandUnsubscribe, "id", s.ID))
+}
+
+// Ack acknowledges a MESSAGE frame received on a subscription with a client ack mode.
+func (c *Client) Ack(msg *Frame) error {
+	return c.write(NewFrame(CommandAck, "id", msg.Get("ack")))
+}
+
+// Nack rejects a MESSAGE frame. The requeue header is a RabbitMQ extension; without it RabbitMQ
+// requeues every nacked message.
+func (c *Client) Nack(msg *Frame, requeue bool) error {
+	return c.write(NewFrame(CommandNack, "id", msg.Get("ack"), "requeue", strconv.FormatBool(requeue)))
+}
+
+// Disconnect waits for the broker to process everything sent so far, then closes the connection.
+func (c *Client) Disconnect() error {
+	err := c.writeWithReceipt(NewFrame(CommandDisconnect))
+	c.shutdown(ErrClosed)
+	if errors.Is(err, ErrClosed) {
+		return nil
+	}
+	return err
+}
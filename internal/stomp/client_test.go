@@ -0,0 +1,229 @@
+package stomp
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroker is an in-process STOMP broker. It answers CONNECT, acknowledges receipts and
+// delivers every SEND to the subscriptions on the same destination. Every frame it reads is kept
+// in frames for the test to look at.
+type fakeBroker struct {
+	conn   net.Conn
+	frames chan *Frame
+	// release, if set, holds back receipts until it is closed.
+	release chan struct{}
+
+	writeMu sync.Mutex
+	subs    map[string]string
+	nextID  int
+}
+
+func newFakeBroker(t *testing.T) (*fakeBroker, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	b := &fakeBroker{conn: server, frames: make(chan *Frame, 100), subs: map[string]string{}}
+	t.Cleanup(func() { server.Close() })
+	return b, client
+}
+
+func (b *fakeBroker) serve() {
+	r := bufio.NewReader(b.conn)
+	for {
+		f, err := ReadFrame(r)
+		if err != nil {
+			return
+		}
+		b.frames <- f
+		switch f.Command {
+		case CommandConnect:
+			if f.Get("login") != "guest" {
+				b.send(NewFrame(CommandError, "message", "access refused"))
+				continue
+			}
+			b.send(NewFrame(CommandConnected, "version", "1.2"))
+		case CommandSubscribe:
+			b.subs[f.Get("id")] = f.Get("destination")
+		case CommandSend:
+			for id, destination := range b.subs {
+				if destination != f.Get("destination") {
+					continue
+				}
+				b.nextID++
+				msg := NewFrame(CommandMessage,
+					"subscription", id,
+					"message-id", strconv.Itoa(b.nextID),
+					"ack", "ack-"+strconv.Itoa(b.nextID),
+					"destination", destination,
+					"content-type", f.Get("content-type"),
+				)
+				msg.Body = f.Body
+				go b.send(msg)
+			}
+		}
+		if receipt := f.Get("receipt"); receipt != "" {
+			go func() {
+				if b.release != nil {
+					<-b.release
+				}
+				b.send(NewFrame(CommandReceipt, "receipt-id", receipt))
+			}()
+		}
+	}
+}
+
+func (b *fakeBroker) send(f *Frame) {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	f.WriteTo(b.conn)
+}
+
+// expect returns the next frame the broker read, which must be a command frame.
+func (b *fakeBroker) expect(t *testing.T, command string) *Frame {
+	t.Helper()
+	select {
+	case f := <-b.frames:
+		if f.Command != command {
+			t.Fatalf("broker got %s, expected %s", f.Command, command)
+		}
+		return f
+	case <-time.After(time.Second):
+		t.Fatalf("broker got no %s frame", command)
+		return nil
+	}
+}
+
+func connect(t *testing.T) (*fakeBroker, *Client) {
+	t.Helper()
+	b, conn := newFakeBroker(t)
+	go b.serve()
+	c, err := Connect(conn, "guest", "guest", "/")
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	return b, c
+}
+
+func TestConnect(t *testing.T) {
+	b, _ := connect(t)
+	f := b.expect(t, CommandConnect)
+	for name, want := range map[string]string{"accept-version": "1.2", "host": "/", "login": "guest", "passcode": "guest"} {
+		if got := f.Get(name); got != want {
+			t.Errorf("CONNECT header %s is %q, expected %q", name, got, want)
+		}
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	b, conn := newFakeBroker(t)
+	go b.serve()
+	_, err := Connect(conn, "mallory", "secret", "/")
+	if err == nil || !strings.Contains(err.Error(), "access refused") {
+		t.Fatalf("got %v, expected the broker's refusal", err)
+	}
+}
+
+func TestSubscribeWaitsForReceipt(t *testing.T) {
+	b, conn := newFakeBroker(t)
+	b.release = make(chan struct{})
+	go b.serve()
+	c, err := Connect(conn, "guest", "guest", "/")
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	b.expect(t, CommandConnect)
+
+	subscribed := make(chan error, 1)
+	go func() {
+		_, err := c.Subscribe("/exchange/peril_topic/game.*", AckClientIndividual, "durable", "true")
+		subscribed <- err
+	}()
+	f := b.expect(t, CommandSubscribe)
+	if f.Get("receipt") == "" {
+		t.Fatal("SUBSCRIBE asks for no receipt")
+	}
+	if f.Get("destination") != "/exchange/peril_topic/game.*" || f.Get("ack") != string(AckClientIndividual) || f.Get("durable") != "true" {
+		t.Errorf("unexpected SUBSCRIBE headers: %v", f.Headers)
+	}
+	select {
+	case err := <-subscribed:
+		t.Fatalf("Subscribe returned before the receipt: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(b.release)
+	select {
+	case err := <-subscribed:
+		if err != nil {
+			t.Fatalf("could not subscribe: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe did not return after the receipt")
+	}
+	c.mu.Lock()
+	pending := len(c.receipts)
+	c.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d receipt(s) still pending", pending)
+	}
+}
+
+func TestSendAndAck(t *testing.T) {
+	b, c := connect(t)
+	b.expect(t, CommandConnect)
+	sub, err := c.Subscribe("/exchange/peril_topic/game.main", AckClientIndividual)
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	b.expect(t, CommandSubscribe)
+
+	if err := c.Send("/exchange/peril_topic/game.main", "text/plain", []byte("hello\x00world")); err != nil {
+		t.Fatalf("could not send: %v", err)
+	}
+	send := b.expect(t, CommandSend)
+	if send.Get("content-length") != "11" {
+		t.Errorf("SEND has content-length %q, expected 11", send.Get("content-length"))
+	}
+
+	var msg *Frame
+	select {
+	case msg = <-sub.C:
+	case <-time.After(time.Second):
+		t.Fatal("no message was delivered")
+	}
+	if string(msg.Body) != "hello\x00world" || msg.Get("content-type") != "text/plain" {
+		t.Errorf("got %q (%s)", msg.Body, msg.Get("content-type"))
+	}
+
+	if err := c.Ack(msg); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+	if ack := b.expect(t, CommandAck); ack.Get("id") != msg.Get("ack") {
+		t.Errorf("ACK id is %q, expected %q", ack.Get("id"), msg.Get("ack"))
+	}
+	if err := c.Nack(msg, false); err != nil {
+		t.Fatalf("could not nack: %v", err)
+	}
+	if nack := b.expect(t, CommandNack); nack.Get("id") != msg.Get("ack") || nack.Get("requeue") != "false" {
+		t.Errorf("unexpected NACK headers: %v", nack.Headers)
+	}
+}
+
+func TestFailedWriteForgetsReceipt(t *testing.T) {
+	b, c := connect(t)
+	b.expect(t, CommandConnect)
+	b.conn.Close()
+
+	if _, err := c.Subscribe("/exchange/peril_topic/game.main", AckClientIndividual); err == nil {
+		t.Fatal("subscribed over a closed connection")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.receipts) != 0 {
+		t.Errorf("%d receipt(s) left behind by the failed write", len(c.receipts))
+	}
+}
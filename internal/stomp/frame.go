@@ -0,0 +1,238 @@
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Client and server commands of STOMP 1.2.
+const (
+	CommandConnect     = "CONNECT"
+	CommandConnected   = "CONNECTED"
+	CommandSend        = "SEND"
+	CommandSubscribe   = "SUBSCRIBE"
+	CommandUnsubscribe = "UNSUBSCRIBE"
+	CommandAck         = "ACK"
+	CommandNack        = "NACK"
+	CommandDisconnect  = "DISCONNECT"
+	CommandMessage     = "MESSAGE"
+	CommandReceipt     = "RECEIPT"
+	CommandError       = "ERROR"
+)
+
+// Limits on what ReadFrame accepts, so a broken or hostile peer can not make it allocate without
+// bound.
+const (
+	MaxBodySize   = 1 << 20
+	maxLineLength = 64 << 10
+	maxHeaders    = 128
+)
+
+var ErrFrameTooLarge = errors.New("stomp frame is too large")
+
+// Frame is a single STOMP frame. Headers keep their order, and the first occurrence of a repeated
+// header wins as required by the spec.
+type Frame struct {
+	Command string
+	Headers [][2]string
+	Body    []byte
+}
+
+func NewFrame(command string, headers ...string) *Frame {
+	f := &Frame{Command: command}
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.Set(headers[i], headers[i+1])
+	}
+	return f
+}
+
+func (f *Frame) Get(name string) string {
+	for _, h := range f.Headers {
+		if h[0] == name {
+			return h[1]
+		}
+	}
+	return ""
+}
+
+func (f *Frame) Set(name, value string) {
+	for i, h := range f.Headers {
+		if h[0] == name {
+			f.Headers[i][1] = value
+			return
+		}
+	}
+	f.Headers = append(f.Headers, [2]string{name, value})
+}
+
+// headerMap returns the headers of f as a map, for pubsub.Message.
+func (f *Frame) headerMap() map[string]any {
+	headers := make(map[string]any, len(f.Headers))
+	for _, h := range f.Headers {
+		if _, ok := headers[h[0]]; !ok {
+			headers[h[0]] = h[1]
+		}
+	}
+	return headers
+}
+
+var headerEscaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+
+// CONNECT and CONNECTED frames are exempt from header escaping for 1.0 compatibility.
+func (f *Frame) escapes() bool {
+	return f.Command != CommandConnect && f.Command != CommandConnected
+}
+
+func (f *Frame) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+	for _, h := range f.Headers {
+		name, value := h[0], h[1]
+		if f.escapes() {
+			name, value = headerEscaper.Replace(name), headerEscaper.Replace(value)
+		}
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+	if len(f.Body) > 0 && f.Get("content-length") == "" {
+		buf.WriteString("content-length:" + strconv.Itoa(len(f.Body)) + "\n")
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.WriteTo(w)
+}
+
+func unescapeHeader(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("header ends with an escape character")
+		}
+		switch s[i] {
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			return "", fmt.Errorf("undefined escape sequence \\%c", s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// ReadFrame reads the next frame, skipping heart-beat end-of-lines between frames.
+func ReadFrame(r *bufio.Reader) (*Frame, error) {
+	var command string
+	for command == "" {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		command = line
+	}
+
+	f := &Frame{Command: command}
+	for lines := 0; ; lines++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		if lines == maxHeaders {
+			return nil, ErrFrameTooLarge
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed header: %q", line)
+		}
+		if f.escapes() {
+			if name, err = unescapeHeader(name); err != nil {
+				return nil, err
+			}
+			if value, err = unescapeHeader(value); err != nil {
+				return nil, err
+			}
+		}
+		if f.Get(name) == "" {
+			f.Headers = append(f.Headers, [2]string{name, value})
+		}
+	}
+
+	if length := f.Get("content-length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid content-length: %q", length)
+		}
+		if n > MaxBodySize {
+			return nil, ErrFrameTooLarge
+		}
+		f.Body = make([]byte, n)
+		if _, err := io.ReadFull(r, f.Body); err != nil {
+			return nil, err
+		}
+		terminator, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if terminator != 0 {
+			return nil, errors.New("frame body is not terminated by NULL")
+		}
+		return f, nil
+	}
+
+	body, err := readUntil(r, 0, MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	f.Body = body
+	return f, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := readUntil(r, '\n', maxLineLength)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// readUntil reads up to delim, which it drops, failing once more than limit bytes came before it.
+func readUntil(r *bufio.Reader, delim byte, limit int) ([]byte, error) {
+	var data []byte
+	for {
+		chunk, err := r.ReadSlice(delim)
+		if len(data)+len(chunk) > limit+1 {
+			return nil, ErrFrameTooLarge
+		}
+		data = append(data, chunk...)
+		if err == nil {
+			return data[:len(data)-1], nil
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	f := NewFrame(CommandSend, "destination", "/exchange/peril_topic/game.main", "note", "a:b\nc\\d")
+	f.Body = []byte("with\x00null")
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFrame(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("could not read frame: %v", err)
+	}
+	if got.Command != CommandSend || got.Get("note") != "a:b\nc\\d" || string(got.Body) != "with\x00null" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestReadFrameLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+	}{
+		{"content-length", "SEND\ncontent-length:" + strconv.Itoa(MaxBodySize+1) + "\n\n"},
+		{"null terminated body", "SEND\n\n" + strings.Repeat("x", MaxBodySize+1) + "\x00"},
+		{"header line", "SEND\nx:" + strings.Repeat("x", maxLineLength) + "\n\n\x00"},
+		{"header count", "SEND\n" + strings.Repeat("x:y\n", maxHeaders+1) + "\n\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadFrame(bufio.NewReader(strings.NewReader(tt.frame)))
+			if !errors.Is(err, ErrFrameTooLarge) {
+				t.Errorf("got %v, expected ErrFrameTooLarge", err)
+			}
+		})
+	}
+
+	body := strings.Repeat("x", MaxBodySize)
+	f, err := ReadFrame(bufio.NewReader(strings.NewReader("SEND\n\n" + body + "\x00")))
+	if err != nil || len(f.Body) != MaxBodySize {
+		t.Errorf("a body of exactly MaxBodySize was refused: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package stomp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// Transport adapts a Client to pubsub.Transport using RabbitMQ's STOMP destinations, where
+// /exchange/peril_topic/army_moves.* subscribes a queue bound to peril_topic with the key army_moves.*.
+type Transport struct {
+	client *Client
+}
+
+func NewTransport(client *Client) *Transport {
+	return &Transport{client: client}
+}
+
+func exchangeDestination(exchange, key string) string {
+	return "/exchange/" + exchange + "/" + key
+}
+
+// Publish sends the message as persistent. RabbitMQ reads the expiration and priority headers like
+// the AMQP properties of the same name.
+func (t *Transport) Publish(exchange, key string, msg pubsub.Message) error {
+	headers := []string{"persistent", "true"}
+	if msg.Expiration > 0 {
+		headers = append(headers, "expiration", strconv.FormatInt(msg.Expiration.Milliseconds(), 10))
+	}
+	if msg.Priority > 0 {
+		headers = append(headers, "priority", strconv.Itoa(int(msg.Priority)))
+	}
+	return t.client.Send(exchangeDestination(exchange, key), msg.ContentType, msg.Body, headers...)
+}
+
+// Subscribe passes args on as SUBSCRIBE headers, which RabbitMQ uses to declare the queue.
+func (t *Transport) Subscribe(exchange, queueName, key string, simpleQueueType pubsub.SimpleQueueType, args map[string]any) (<-chan pubsub.Delivery, error) {
+	headers := []string{
+		"x-queue-name", queueName,
+		"prefetch-count", strconv.Itoa(subscriptionBuffer),
+	}
+	switch simpleQueueType {
+	case pubsub.SimpleQueueTypeDurable, pubsub.SimpleQueueTypeQuorum:
+		headers = append(headers, "durable", "true", "auto-delete", "false")
+	case pubsub.SimpleQueueTypeTransient:
+		headers = append(headers, "durable", "false", "auto-delete", "true", "exclusive", "true")
+	default:
+		return nil, fmt.Errorf("queue type %v is not supported over STOMP", simpleQueueType)
+	}
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		headers = append(headers, name, fmt.Sprint(args[name]))
+	}
+
+	sub, err := t.client.Subscribe(exchangeDestination(exchange, key), AckClientIndividual, headers...)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan pubsub.Delivery)
+	go func() {
+		defer close(deliveries)
+		for f := range sub.C {
+			f := f
+			deliveries <- pubsub.Delivery{
+				Message: pubsub.Message{
+					RoutingKey:  routingKey(f.Get("destination")),
+					ContentType: f.Get("content-type"),
+					Headers:     f.headerMap(),
+					Body:        f.Body,
+				},
+				Ack:  func() error { return t.client.Ack(f) },
+				Nack: func(requeue bool) error { return t.client.Nack(f, requeue) },
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+func (t *Transport) Close() error {
+	return t.client.Disconnect()
+}
+
+// routingKey extracts the routing key from a /exchange/<exchange>/<key> destination.
+func routingKey(destination string) string {
+	rest, ok := strings.CutPrefix(destination, "/exchange/")
+	if !ok {
+		return destination
+	}
+	_, key, _ := strings.Cut(rest, "/")
+	return key
+}
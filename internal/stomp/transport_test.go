@@ -0,0 +1,101 @@
+package stomp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+type greeting struct {
+	From string
+	Text string
+}
+
+func TestTransportSignsAndEncrypts(t *testing.T) {
+	b, c := connect(t)
+	b.expect(t, CommandConnect)
+	transport := NewTransport(c)
+
+	dir := t.TempDir()
+	alice, err := pubsub.LoadIdentity(dir, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := pubsub.LoadIdentity(dir, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := pubsub.NewKeyStore()
+	keys.Trust(alice.Public())
+	keys.Trust(bob.Public())
+
+	received := make(chan greeting, 1)
+	err = pubsub.SubscribeJSONTransport(transport, "peril_topic", "greetings", "greetings.bob", pubsub.SimpleQueueTypeDurable,
+		func(g greeting) pubsub.AckType {
+			received <- g
+			return pubsub.Ack
+		},
+		pubsub.WithVerifier(keys, func(g greeting) string { return g.From }),
+		pubsub.WithDecryption(bob),
+		pubsub.WithMessageTTL(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	sub := b.expect(t, CommandSubscribe)
+	for name, want := range map[string]string{"x-queue-name": "greetings", "durable": "true", "x-dead-letter-exchange": pubsub.DeadLetterExchange, "x-message-ttl": "60000"} {
+		if got := sub.Get(name); got != want {
+			t.Errorf("SUBSCRIBE header %s is %q, expected %q", name, got, want)
+		}
+	}
+
+	err = pubsub.PublishJSONTransport(transport, "peril_topic", "greetings.bob", greeting{From: "alice", Text: "hi"},
+		pubsub.WithSigner(alice), pubsub.WithEncryption(keys, "bob"), pubsub.WithExpiration(time.Second))
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	send := b.expect(t, CommandSend)
+	if send.Get("expiration") != "1000" {
+		t.Errorf("SEND has expiration %q, expected 1000", send.Get("expiration"))
+	}
+	if string(send.Body) == `{"From":"alice","Text":"hi"}` {
+		t.Error("the message went out in the clear")
+	}
+
+	select {
+	case g := <-received:
+		if g.Text != "hi" {
+			t.Errorf("got %+v", g)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the greeting was not delivered")
+	}
+	b.expect(t, CommandAck)
+}
+
+func TestTransportRejectsUnsigned(t *testing.T) {
+	b, c := connect(t)
+	b.expect(t, CommandConnect)
+	transport := NewTransport(c)
+
+	err := pubsub.SubscribeJSONTransport(transport, "peril_topic", "greetings", "greetings.bob", pubsub.SimpleQueueTypeDurable,
+		func(g greeting) pubsub.AckType {
+			t.Errorf("handled an unsigned message: %+v", g)
+			return pubsub.Ack
+		},
+		pubsub.WithVerifier(pubsub.NewKeyStore(), func(g greeting) string { return g.From }),
+	)
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	b.expect(t, CommandSubscribe)
+
+	if err := pubsub.PublishJSONTransport(transport, "peril_topic", "greetings.bob", greeting{From: "alice", Text: "hi"}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	b.expect(t, CommandSend)
+	if nack := b.expect(t, CommandNack); nack.Get("requeue") != "false" {
+		t.Errorf("unsigned message was requeued: %v", nack.Headers)
+	}
+}